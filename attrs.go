@@ -0,0 +1,417 @@
+package slogtfmt
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// leafAttr is a resolved non-group attribute with the prefix of its enclosing groups.
+type leafAttr struct {
+	prefix string
+	attr   slog.Attr
+}
+
+// hasKey reports whether the full key of the attribute equals key, without allocating it.
+func (l leafAttr) hasKey(key string) bool {
+	return len(key) == len(l.prefix)+len(l.attr.Key) &&
+		strings.HasPrefix(key, l.prefix) && key[len(l.prefix):] == l.attr.Key
+}
+
+// collectsAttrs reports whether the attributes must be collected before they are appended,
+// because they need to be reordered.
+func (h *Handler) collectsAttrs() bool {
+	return len(h.opts.PriorityKeys) > 0 || h.opts.AddRecordID || h.opts.SortAttrs != SortNone ||
+		len(h.opts.PositionalKeys) > 0 || h.opts.DedupKeys
+}
+
+// collectAttrs returns the resolved non-group attributes of the given groups and attributes
+// and of the record, in their original order.
+func (h *Handler) collectAttrs(goas []groupOrAttrs, r slog.Record) []leafAttr {
+	leaves := make([]leafAttr, 0, r.NumAttrs()+8)
+	groupPrefix := ""
+	for _, goa := range goas {
+		if goa.group != "" {
+			groupPrefix = h.groupPrefix(groupPrefix, h.alias(goa.group))
+		}
+		for _, a := range goa.attrs {
+			if !isTag(a) && a.Key != timeFormatKeyName && !h.isCorrelationID(a, groupPrefix) {
+				leaves = h.collectAttr(leaves, a, groupPrefix, 0)
+			}
+		}
+	}
+	handlerLeaves := len(leaves)
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key != timeFormatKeyName && !h.isCorrelationID(a, groupPrefix) {
+			leaves = h.collectAttr(leaves, a, groupPrefix, 0)
+		}
+		return true
+	})
+	if h.opts.DedupKeys {
+		leaves = h.dedupAttrs(leaves, handlerLeaves)
+	}
+	return leaves
+}
+
+// dedupAttrs removes all but the last of the attributes with the same full key. The first
+// handlerLeaves attributes are the attributes of the Handler, whose replacement by the record
+// attributes is reported if WarnShadowedKeys is set.
+func (h *Handler) dedupAttrs(leaves []leafAttr, handlerLeaves int) []leafAttr {
+	// The keys are compared as written, with the group separators in the keys escaped,
+	// so that an escaped key doesn't collide with a group member.
+	last := make(map[string]int, len(leaves))
+	for i, leaf := range leaves {
+		last[leaf.prefix+h.escapeKey(leaf.attr.Key)] = i
+	}
+	if len(last) == len(leaves) {
+		return leaves
+	}
+
+	deduped := leaves[:0]
+	for i, leaf := range leaves {
+		key := leaf.prefix + h.escapeKey(leaf.attr.Key)
+		j := last[key]
+		if i == j {
+			deduped = append(deduped, leaf)
+			continue
+		}
+		if h.opts.WarnShadowedKeys && i < handlerLeaves && j >= handlerLeaves && h.opts.OnError != nil {
+			if _, reported := h.state.shadowedKeys.LoadOrStore(key, true); !reported {
+				h.opts.OnError(fmt.Errorf("%w: %q", ErrShadowedKey, key))
+			}
+		}
+	}
+	return deduped
+}
+
+// collectAttr appends the resolved non-group attributes of attr to leaves.
+// It follows the same rules as appendAttr.
+func (h *Handler) collectAttr(leaves []leafAttr, attr slog.Attr, prefix string, depth int) []leafAttr {
+	attr.Value = h.resolve(attr.Value)
+	attr.Key = h.alias(attr.Key)
+
+	// Ignore empty attrs.
+	if attr.Equal(slog.Attr{}) {
+		return leaves
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		attrs := attr.Value.Group()
+		if len(attrs) == 0 && h.opts.EmitEmpty && attr.Key != "" {
+			return append(leaves, leafAttr{prefix: prefix, attr: attr})
+		}
+		if len(attrs) > 0 && h.groupTooDeep(depth) {
+			prefix, key := h.truncatedGroupKey(prefix, attr.Key)
+			return append(leaves, leafAttr{prefix: prefix, attr: slog.Any(key, truncatedGroup{})})
+		}
+		if attr.Key != "" && len(attrs) > 0 {
+			prefix = h.groupPrefix(prefix, attr.Key)
+		}
+		for _, a := range attrs {
+			leaves = h.collectAttr(leaves, a, prefix, depth+1)
+		}
+		return leaves
+	}
+
+	return append(leaves, leafAttr{prefix: prefix, attr: attr})
+}
+
+// AttrMap returns the attributes the Handler writes for the record, including its constant
+// attributes and the attributes added with WithAttrs, as a map from the keys prefixed with
+// the group names, e.g. "http.status", to the resolved values. The keys are the same as in
+// the formatted output, so it can be used to inspect what would be logged without parsing
+// the output. If a key occurs more than once, the last value is kept.
+func (h *Handler) AttrMap(r slog.Record) map[string]any {
+	var leaves []leafAttr
+	for _, a := range h.opts.ConstantAttrs {
+		leaves = h.collectAttr(leaves, a, "", 0)
+	}
+	if h.opts.BuildInfo {
+		leaves = h.collectAttr(leaves, buildInfoAttr(), "", 0)
+	}
+	if tag, ok := h.joinedTags(); ok && h.opts.TagMode != TagBracket && h.opts.JoinTags != "" {
+		leaves = append(leaves, leafAttr{attr: slog.String(h.opts.TagKey, tag)})
+	} else if h.opts.TagMode != TagBracket {
+		for _, goa := range h.goas {
+			for _, a := range goa.attrs {
+				if isTag(a) {
+					leaves = append(leaves, leafAttr{attr: slog.String(h.opts.TagKey, a.Value.String())})
+				}
+			}
+		}
+	}
+	leaves = append(leaves, h.collectAttrs(h.goas, r)...)
+	if id, ok := h.correlationID(r); ok {
+		leaves = append(leaves, leafAttr{attr: slog.String(h.opts.CorrelationKey, id)})
+	}
+
+	m := make(map[string]any, len(leaves))
+	for _, leaf := range leaves {
+		key := h.opts.KeyNamespace + leaf.prefix + h.escapeKey(leaf.attr.Key)
+		if h.opts.ExpandErrorChain && leaf.attr.Value.Kind() == slog.KindAny {
+			if err, ok := leaf.attr.Value.Any().(error); ok {
+				m[key] = err.Error()
+				if cause := innermostError(err); cause != err {
+					m[h.opts.KeyNamespace+h.groupPrefix(leaf.prefix, leaf.attr.Key)+"cause"] = cause.Error()
+				}
+				continue
+			}
+		}
+		m[key] = leaf.attr.Value.Any()
+	}
+	return m
+}
+
+// resolveRecord returns a copy of the record with the values of its attributes and group members
+// resolved, so the values are resolved only once by checkKeys and the formatting.
+func (h *Handler) resolveRecord(r slog.Record) slog.Record {
+	r2 := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		r2.AddAttrs(h.resolveAttr(a, 0))
+		return true
+	})
+	return r2
+}
+
+// resolveAttr returns the attribute with its value and group members resolved, up to the MaxGroupDepth.
+// Values resolving to an empty group are kept unresolved, because slog drops empty groups from
+// records and groups, but they are written with EmitEmpty.
+func (h *Handler) resolveAttr(attr slog.Attr, depth int) slog.Attr {
+	v := h.resolve(attr.Value)
+	if v.Kind() != slog.KindGroup {
+		attr.Value = v
+		return attr
+	}
+	members := v.Group()
+	if len(members) == 0 {
+		return attr
+	}
+	if !h.groupTooDeep(depth) {
+		resolved := make([]slog.Attr, len(members))
+		for i, a := range members {
+			resolved[i] = h.resolveAttr(a, depth+1)
+		}
+		v = slog.GroupValue(resolved...)
+	}
+	attr.Value = v
+	return attr
+}
+
+// checkKeys returns an error wrapping ErrInvalidKey if the groups or attributes of the Handler,
+// checked by WithGroup and WithAttrs, or the attributes of the record, which must be resolved
+// with resolveRecord, have malformed keys.
+func (h *Handler) checkKeys(r slog.Record) error {
+	errs := slices.Clip(h.keyErrs)
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key != timeFormatKeyName {
+			errs = h.checkKey(errs, a, 0)
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+// checkKey appends the errors of the malformed keys of the attribute and its group members to errs.
+func (h *Handler) checkKey(errs []error, attr slog.Attr, depth int) []error {
+	if attr.Value.Kind() == slog.KindLogValuer {
+		// Only the values resolving to an empty group are left unresolved by resolveAttr.
+		attr.Value = h.resolve(attr.Value)
+	}
+	if attr.Equal(slog.Attr{}) {
+		return errs
+	}
+	isGroup := attr.Value.Kind() == slog.KindGroup
+	switch {
+	case attr.Key == "" && !isGroup:
+		errs = append(errs, fmt.Errorf("%w: empty key with value %q", ErrInvalidKey, attr.Value.String()))
+	case h.ambiguousKey(attr.Key):
+		errs = append(errs, fmt.Errorf("%w: key %q contains %q", ErrInvalidKey, attr.Key, h.opts.GroupSeparator))
+	}
+	if isGroup && !h.groupTooDeep(depth) {
+		for _, a := range attr.Value.Group() {
+			errs = h.checkKey(errs, a, depth+1)
+		}
+	}
+	return errs
+}
+
+// ambiguousKey reports whether the key or group name contains the group separator
+// and is not escaped.
+func (h *Handler) ambiguousKey(key string) bool {
+	return !h.opts.EscapeKeys && strings.Contains(key, h.opts.GroupSeparator)
+}
+
+// orderAttrs reorders the attributes according to the configured ordering options.
+func (h *Handler) orderAttrs(leaves []leafAttr) []leafAttr {
+	if h.opts.AddRecordID {
+		// Sort by key for a stable record ID. The priority keys are sorted below.
+		sort.SliceStable(leaves, func(i, j int) bool {
+			return leaves[i].prefix+leaves[i].attr.Key < leaves[j].prefix+leaves[j].attr.Key
+		})
+	}
+	switch h.opts.SortAttrs {
+	case SortByKey:
+		sort.SliceStable(leaves, func(i, j int) bool {
+			return leaves[i].prefix+leaves[i].attr.Key < leaves[j].prefix+leaves[j].attr.Key
+		})
+	case SortGrouped:
+		sort.SliceStable(leaves, func(i, j int) bool {
+			return h.groupedLess(leaves[i], leaves[j])
+		})
+	}
+	if len(h.opts.PriorityKeys) > 0 {
+		sort.SliceStable(leaves, func(i, j int) bool {
+			return h.priority(leaves[i]) < h.priority(leaves[j])
+		})
+	}
+	return leaves
+}
+
+// groupedLess reports whether the attribute a is sorted before b by SortGrouped. At each group
+// level, the attributes come before the nested groups and both are sorted by name.
+func (h *Handler) groupedLess(a, b leafAttr) bool {
+	pa, pb := a.prefix, b.prefix
+	for {
+		ga, restA, inGroupA := h.cutGroup(pa)
+		gb, restB, inGroupB := h.cutGroup(pb)
+		switch {
+		case !inGroupA && !inGroupB:
+			return a.attr.Key < b.attr.Key
+		case !inGroupA || !inGroupB:
+			return !inGroupA
+		case ga != gb:
+			return ga < gb
+		}
+		pa, pb = restA, restB
+	}
+}
+
+// cutGroup slices the key prefix around the first group separator that is not escaped,
+// returning the name of the outermost group and the prefix of the nested groups.
+func (h *Handler) cutGroup(prefix string) (group, rest string, found bool) {
+	sep := h.opts.GroupSeparator
+	for i := 0; i < len(prefix); i++ {
+		switch {
+		case h.opts.EscapeKeys && prefix[i] == '\\':
+			i++
+		case strings.HasPrefix(prefix[i:], sep):
+			return prefix[:i], prefix[i+len(sep):], true
+		}
+	}
+	return prefix, "", false
+}
+
+// priority returns the index of the attribute key in PriorityKeys,
+// or the number of the priority keys if it's not one of them.
+func (h *Handler) priority(leaf leafAttr) int {
+	for i, key := range h.opts.PriorityKeys {
+		if h.hasKey(leaf, key) {
+			return i
+		}
+	}
+	return len(h.opts.PriorityKeys)
+}
+
+// hasKey reports whether the full key of the attribute, escaped if EscapeKeys is set, equals key.
+func (h *Handler) hasKey(leaf leafAttr, key string) bool {
+	leaf.attr.Key = h.escapeKey(leaf.attr.Key)
+	return leaf.hasKey(key)
+}
+
+// correlationID returns the value of the last attribute with the CorrelationKey among
+// the attributes of the Handler and of the record, if any.
+func (h *Handler) correlationID(r slog.Record) (string, bool) {
+	if h.opts.CorrelationKey == "" {
+		return "", false
+	}
+	var id slog.Value
+	found := false
+	prefix := ""
+	for _, goa := range h.goas {
+		if goa.group != "" {
+			prefix = h.groupPrefix(prefix, h.alias(goa.group))
+		}
+		for _, a := range goa.attrs {
+			if h.isCorrelationID(a, prefix) {
+				id, found = a.Value, true
+			}
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if h.isCorrelationID(a, prefix) {
+			id, found = a.Value, true
+		}
+		return true
+	})
+	if !found {
+		return "", false
+	}
+	return h.resolve(id).String(), true
+}
+
+// isCorrelationID reports whether the attribute with the given prefix has the CorrelationKey.
+func (h *Handler) isCorrelationID(a slog.Attr, prefix string) bool {
+	return h.opts.CorrelationKey != "" && h.hasKey(leafAttr{prefix: prefix, attr: a}, h.opts.CorrelationKey)
+}
+
+// appendPositional appends the values of the attributes with the PositionalKeys, or "" for
+// the missing ones, and returns the other attributes. With ExpandErrorChain, the causes of
+// the positional error values are returned as keyed attributes before the other attributes.
+func (h *Handler) appendPositional(buf []byte, leaves []leafAttr) ([]byte, []leafAttr) {
+	written := make([]bool, len(leaves))
+	var causes []leafAttr
+	for _, key := range h.opts.PositionalKeys {
+		i := 0
+		for i < len(leaves) && (written[i] || !h.hasKey(leaves[i], key)) {
+			i++
+		}
+		if i == len(leaves) {
+			buf = append(buf, h.attrSeparator()...)
+			buf = append(buf, `""`...)
+			continue
+		}
+		written[i] = true
+		leaf := leaves[i]
+		buf = h.appendLeafValue(buf, leaf.attr, leaf.prefix, false)
+		if cause, ok := h.errorCause(leaf.attr.Value); ok {
+			causes = append(causes, leafAttr{
+				prefix: h.groupPrefix(leaf.prefix, leaf.attr.Key),
+				attr:   slog.String("cause", cause),
+			})
+		}
+	}
+
+	rest := make([]leafAttr, 0, len(causes)+len(leaves))
+	rest = append(rest, causes...)
+	for i, leaf := range leaves {
+		if !written[i] {
+			rest = append(rest, leaf)
+		}
+	}
+	return buf, rest
+}
+
+// errorCause returns the message of the innermost error of an error value whose chain is
+// expanded with ExpandErrorChain, if it differs from the error. A panicking error method
+// is ignored; it's reported when the value itself is appended.
+func (h *Handler) errorCause(v slog.Value) (msg string, ok bool) {
+	if !h.opts.ExpandErrorChain || v.Kind() != slog.KindAny {
+		return "", false
+	}
+	err, isErr := v.Any().(error)
+	if !isErr {
+		return "", false
+	}
+	defer func() {
+		if recover() != nil {
+			msg, ok = "", false
+		}
+	}()
+	if cause := innermostError(err); cause != err {
+		return cause.Error(), true
+	}
+	return "", false
+}
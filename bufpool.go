@@ -1,6 +1,9 @@
 package slogtfmt
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 const (
 	initialBufferSize = 1024
@@ -17,8 +20,23 @@ var bufPool = sync.Pool{
 	},
 }
 
+// bufPoolDisabled reports whether the buffers are allocated without the pool.
+var bufPoolDisabled atomic.Bool
+
+// DisableBufPool sets whether the handlers allocate a new buffer for every record
+// instead of reusing the buffers from a pool. Disabling the pool helps debugging
+// buffer reuse or allocation issues, but every record then costs at least one
+// additional allocation of initialBufferSize bytes. The pool is enabled by default.
+func DisableBufPool(disable bool) {
+	bufPoolDisabled.Store(disable)
+}
+
 // allocBuf returns a new byte slice from the bufPool. The byte slice will have an initial capacity of initialBufferSize.
 func allocBuf() *[]byte {
+	if bufPoolDisabled.Load() {
+		b := make([]byte, 0, initialBufferSize)
+		return &b
+	}
 	return bufPool.Get().(*[]byte)
 }
 
@@ -26,7 +44,7 @@ func allocBuf() *[]byte {
 // will not be returned to the pool to reduce peak memory usage.
 func freeBuf(b *[]byte) {
 	// To reduce peak allocation, return only smaller buffers to the pool.
-	if cap(*b) > maxBufferSize {
+	if cap(*b) > maxBufferSize || bufPoolDisabled.Load() {
 		return
 	}
 	*b = (*b)[:0]
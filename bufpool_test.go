@@ -0,0 +1,74 @@
+package slogtfmt
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreeBufDropsLargeBuffers(t *testing.T) {
+	large := make([]byte, 0, 2*maxBufferSize)
+	freeBuf(&large)
+
+	// The pool may return a previously pooled buffer or a new one,
+	// but never a buffer larger than maxBufferSize.
+	for i := 0; i < 10; i++ {
+		b := allocBuf()
+		assert.LessOrEqual(t, cap(*b), maxBufferSize)
+		assert.Empty(t, *b)
+	}
+}
+
+// capWriter records the capacity of the last written slice.
+type capWriter struct {
+	bytes.Buffer
+	lastCap int
+}
+
+func (w *capWriter) Write(p []byte) (int, error) {
+	w.lastCap = cap(p)
+	return w.Buffer.Write(p)
+}
+
+func TestHandleDropsLargeBuffers(t *testing.T) {
+	var w capWriter
+	logger := slog.New(NewHandler(&w, &Options{TimeFormat: ""}))
+	logger.Info("large", "v", strings.Repeat("x", 2*maxBufferSize))
+	assert.Greater(t, w.lastCap, maxBufferSize)
+	assert.Equal(t, 2*maxBufferSize+len("INFO\tlarge v=\"\"\n"), w.Len())
+
+	// The grown buffer of the record is not returned to the pool.
+	for i := 0; i < 10; i++ {
+		b := allocBuf()
+		assert.LessOrEqual(t, cap(*b), maxBufferSize)
+		defer freeBuf(b)
+	}
+}
+
+func TestFreeBufResetsLength(t *testing.T) {
+	b := allocBuf()
+	*b = append(*b, "data"...)
+	freeBuf(b)
+	assert.Empty(t, *b)
+}
+
+func TestDisableBufPool(t *testing.T) {
+	DisableBufPool(true)
+	defer DisableBufPool(false)
+
+	b1 := allocBuf()
+	*b1 = append(*b1, "data"...)
+	freeBuf(b1)
+	b2 := allocBuf()
+	assert.NotSame(t, b1, b2)
+	assert.Empty(t, *b2)
+
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(&buf, &Options{TimeFormat: ""}))
+	logger.Info("first", "key", "value")
+	logger.Info("second", "n", 2)
+	assert.Equal(t, "INFO\tfirst key=\"value\"\nINFO\tsecond n=2\n", buf.String())
+}
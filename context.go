@@ -0,0 +1,63 @@
+package slogtfmt
+
+import (
+	"context"
+	"log/slog"
+)
+
+// levelContextKey is the context key used to store a level override.
+type levelContextKey struct{}
+
+// tagContextKey is the context key used to store a tag.
+type tagContextKey struct{}
+
+// fallbackContextKey is the context key that marks the records forwarded to the FallbackHandler.
+type fallbackContextKey struct{}
+
+// ContextWithLevel returns a copy of ctx that carries a level override.
+// The Handler uses the override instead of the configured Level for records logged
+// with the returned context, for example with [slog.Logger.DebugContext].
+// This allows enabling verbose logging for a single request.
+func ContextWithLevel(ctx context.Context, level slog.Leveler) context.Context {
+	return context.WithValue(ctx, levelContextKey{}, level)
+}
+
+// levelFromContext returns the level override stored in ctx by ContextWithLevel, if any.
+func levelFromContext(ctx context.Context) (slog.Leveler, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	level, ok := ctx.Value(levelContextKey{}).(slog.Leveler)
+	return level, ok && level != nil
+}
+
+// ContextWithTag returns a copy of ctx that carries a tag. The Handler renders the tag like
+// a tag set with [Tag] for records logged with the returned context, so middleware can set
+// a tag once for all downstream logs without passing a derived logger around.
+// The tags set with [Tag] take precedence: the context tag is only rendered for loggers without tags.
+// Like those, it's quoted in the brackets if it needs quoting, so tags taken from requests can't forge lines.
+func ContextWithTag(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, tagContextKey{}, name)
+}
+
+// tagFromContext returns the tag stored in ctx by ContextWithTag, if any.
+func tagFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	tag, ok := ctx.Value(tagContextKey{}).(string)
+	return tag, ok
+}
+
+// contextWithFallback returns a copy of ctx that marks the record as forwarded to the FallbackHandler.
+func contextWithFallback(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, fallbackContextKey{}, true)
+}
+
+// isFallback reports whether the record logged with ctx was forwarded to the FallbackHandler.
+func isFallback(ctx context.Context) bool {
+	return ctx != nil && ctx.Value(fallbackContextKey{}) != nil
+}
@@ -0,0 +1,70 @@
+package slogtfmt
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextWithLevel(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandler(&buf, &Options{
+		Level:      slog.LevelInfo,
+		TimeFormat: "",
+	})
+	logger := slog.New(handler)
+
+	logger.DebugContext(context.Background(), "not logged")
+	assert.Empty(t, buf.String())
+
+	ctx := ContextWithLevel(context.Background(), slog.LevelDebug)
+	assert.True(t, handler.Enabled(ctx, slog.LevelDebug))
+
+	logger.DebugContext(ctx, "debug message")
+	assert.Equal(t, "DEBUG\tdebug message\n", buf.String())
+
+	// The override can raise the threshold as well.
+	buf.Reset()
+	ctx = ContextWithLevel(context.Background(), slog.LevelError)
+	logger.WarnContext(ctx, "not logged")
+	assert.Empty(t, buf.String())
+
+	// Derived loggers honor the override too.
+	buf.Reset()
+	ctx = ContextWithLevel(context.Background(), slog.LevelDebug)
+	logger.With(Tag("db")).DebugContext(ctx, "query")
+	assert.Equal(t, "DEBUG\t[db]\tquery\n", buf.String())
+}
+
+func TestContextWithTag(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(&buf, &Options{TimeFormat: ""}))
+
+	ctx := ContextWithTag(context.Background(), "http")
+	logger.InfoContext(ctx, "Request", "path", "/")
+	assert.Equal(t, "INFO\t[http]\tRequest path=\"/\"\n", buf.String())
+
+	// Records logged without the context have no tag.
+	buf.Reset()
+	logger.Info("Started")
+	assert.Equal(t, "INFO\tStarted\n", buf.String())
+
+	// The tags set with Tag take precedence.
+	buf.Reset()
+	logger.With(Tag("db")).InfoContext(ctx, "Query")
+	assert.Equal(t, "INFO\t[db]\tQuery\n", buf.String())
+
+	// Context tags may come from untrusted input, so they are quoted if needed.
+	buf.Reset()
+	logger.InfoContext(ContextWithTag(context.Background(), "a]\nb"), "Request")
+	assert.Equal(t, "INFO\t[\"a]\\nb\"]\tRequest\n", buf.String())
+
+	// The context tag is rendered according to TagMode.
+	buf.Reset()
+	logger = slog.New(NewHandler(&buf, &Options{TimeFormat: "", TagMode: TagAttr}))
+	logger.InfoContext(ctx, "Request", "path", "/")
+	assert.Equal(t, "INFO\tRequest tag=\"http\" path=\"/\"\n", buf.String())
+}
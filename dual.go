@@ -0,0 +1,82 @@
+package slogtfmt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+)
+
+// DualFormatHandler is a [slog.Handler] that writes each record in the text format of [Handler]
+// to one writer and in the JSON format of [slog.JSONHandler] to another, for example
+// human-readable output on the console and JSON to a file.
+type DualFormatHandler struct {
+	text *Handler
+	json slog.Handler
+}
+
+// NewDualFormatHandler creates a new DualFormatHandler that writes the text output to textWriter
+// and the JSON output to jsonWriter. The options configure the text output; the JSON output uses
+// the same Level and AddSource, and tags are written in it as attributes with the TagKey.
+//
+// The JSON output is written by a plain slog.JSONHandler with its own buffers, so it ignores all
+// the other options, e.g. ConstantAttrs, BuildInfo, TypeFormatters, KeyAliases, LevelComparator
+// and FallbackHandler, and the tags from the context. Only the attributes of the record and of
+// WithAttrs and WithGroup are written in it.
+func NewDualFormatHandler(textWriter, jsonWriter io.Writer, opts *Options) *DualFormatHandler {
+	text := NewHandler(textWriter, opts)
+	tagKey := text.opts.TagKey
+	json := slog.NewJSONHandler(jsonWriter, &slog.HandlerOptions{
+		Level:     text.opts.Level,
+		AddSource: text.opts.AddSource,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			switch {
+			case isTag(a):
+				a.Key = tagKey
+			case a.Key == timeFormatKeyName:
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	return &DualFormatHandler{text: text, json: json}
+}
+
+// Enabled reports whether either format handles records with the given level.
+func (h *DualFormatHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.text.Enabled(ctx, level) || h.json.Enabled(ctx, level)
+}
+
+// Handle writes the record in both formats and returns the errors of both writes joined.
+func (h *DualFormatHandler) Handle(ctx context.Context, r slog.Record) error {
+	var textErr, jsonErr error
+	if h.text.Enabled(ctx, r.Level) {
+		textErr = h.text.Handle(ctx, r)
+	}
+	if h.json.Enabled(ctx, r.Level) {
+		jsonErr = h.json.Handle(ctx, r)
+	}
+	return errors.Join(textErr, jsonErr)
+}
+
+// WithAttrs returns a new DualFormatHandler whose formats both include the given attributes.
+func (h *DualFormatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return &DualFormatHandler{
+		text: h.text.WithAttrs(attrs).(*Handler),
+		json: h.json.WithAttrs(attrs),
+	}
+}
+
+// WithGroup returns a new DualFormatHandler whose formats both use the given group.
+func (h *DualFormatHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &DualFormatHandler{
+		text: h.text.WithGroup(name).(*Handler),
+		json: h.json.WithGroup(name),
+	}
+}
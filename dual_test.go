@@ -0,0 +1,66 @@
+package slogtfmt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestDualFormatHandler(t *testing.T) {
+	var text, js bytes.Buffer
+	handler := NewDualFormatHandler(&text, &js, &Options{Level: slog.LevelInfo})
+	logger := slog.New(handler).With(Tag("db")).WithGroup("req")
+
+	logger.Debug("skipped")
+	logger.Info("query", "rows", 3, "table", "users")
+	assert.Equal(t, "INFO\t[db]\tquery req.rows=3 req.table=\"users\"\n", text.String())
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(js.Bytes(), &record), js.String())
+	assert.Equal(t, "INFO", record["level"])
+	assert.Equal(t, "query", record["msg"])
+	assert.Equal(t, "db", record["tag"])
+	assert.Equal(t, map[string]any{"rows": float64(3), "table": "users"}, record["req"])
+	assert.Contains(t, record, "time")
+
+	// The tags have the TagKey.
+	js.Reset()
+	handler = NewDualFormatHandler(&text, &js, &Options{TagKey: "component"})
+	slog.New(handler).With(Tag("db")).Info("query")
+	record = nil
+	assert.NoError(t, json.Unmarshal(js.Bytes(), &record), js.String())
+	assert.Equal(t, "db", record["component"])
+	assert.NotContains(t, record, "tag")
+
+	// The other options apply only to the text output.
+	text.Reset()
+	js.Reset()
+	handler = NewDualFormatHandler(&text, &js, &Options{
+		TimeFormat:    "",
+		ConstantAttrs: []slog.Attr{slog.String("svc", "api")},
+		KeyAliases:    map[string]string{"userId": "user_id"},
+	})
+	slog.New(handler).Info("msg", "userId", 1)
+	assert.Equal(t, "INFO\tmsg svc=\"api\" user_id=1\n", text.String())
+	record = nil
+	assert.NoError(t, json.Unmarshal(js.Bytes(), &record), js.String())
+	assert.Equal(t, float64(1), record["userId"])
+	assert.NotContains(t, record, "svc")
+
+	// The errors of both writers are returned.
+	handler = NewDualFormatHandler(failingWriter{}, failingWriter{}, nil)
+	err := handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0))
+	assert.ErrorContains(t, err, "write failed\nwrite failed")
+}
@@ -0,0 +1,130 @@
+package extras
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// BatchWriter is an io.Writer that coalesces records into batches, for writers where many small
+// writes are expensive, such as cloud log APIs. Each Write call is treated as one record,
+// which matches how slogtfmt.Handler writes its output. A batch is written to the underlying
+// writer with a single Write call when adding a record would exceed the batch size, or when
+// the flush interval has passed since the first record of the batch was added:
+//
+//	batch := extras.NewBatchWriter(out, 64<<10, time.Second)
+//	handler := slogtfmt.NewHandler(batch, nil)
+//	defer handler.Close() // flushes the last batch
+//
+// The records are written in order and never split across batches. Records larger than the batch
+// size are written on their own. The write errors are returned by the Write, Flush or Close call
+// that writes the batch, and the errors of the batches written by the timer are returned by
+// the next Flush or Close call; a failed batch is discarded.
+//
+// BatchWriter is safe for concurrent use.
+type BatchWriter struct {
+	out      io.Writer
+	size     int
+	interval time.Duration
+
+	mu     sync.Mutex
+	batch  []byte
+	timer  *time.Timer
+	err    error // errors of the flushes by the timer since the last Flush
+	closed bool
+}
+
+// NewBatchWriter creates a new BatchWriter that writes batches of up to size bytes to out.
+// If interval is positive, a partial batch is written when the interval has passed since
+// its first record was added; otherwise, it's written by Flush or Close.
+func NewBatchWriter(out io.Writer, size int, interval time.Duration) *BatchWriter {
+	return &BatchWriter{
+		out:      out,
+		size:     size,
+		interval: interval,
+		batch:    make([]byte, 0, size),
+	}
+}
+
+// Write adds a copy of p to the batch, writing the batch first if p doesn't fit in it.
+func (w *BatchWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	return w.write(p)
+}
+
+// write adds a copy of p to the batch, writing the batch first if p doesn't fit in it.
+// The caller must hold the mutex.
+func (w *BatchWriter) write(p []byte) (int, error) {
+	if len(w.batch)+len(p) > w.size {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+		if len(p) > w.size {
+			return w.out.Write(p)
+		}
+	}
+
+	if len(w.batch) == 0 && w.interval > 0 {
+		w.timer = time.AfterFunc(w.interval, w.flushTimer)
+	}
+	w.batch = append(w.batch, p...)
+	return len(p), nil
+}
+
+// Flush writes the current batch to the underlying writer. It also returns the errors
+// of the batches written by the timer since the last Flush, if any.
+func (w *BatchWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return errors.Join(w.takeErr(), w.flush())
+}
+
+// Close writes the current batch and returns its error joined with the errors of the batches
+// written by the timer since the last Flush. The BatchWriter can't be written after Close.
+// It doesn't close the underlying writer.
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return errors.Join(w.takeErr(), w.flush())
+}
+
+// flushTimer writes the batch when the flush interval has passed.
+func (w *BatchWriter) flushTimer() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.flush(); err != nil {
+		w.err = errors.Join(w.err, err)
+	}
+}
+
+// takeErr returns and clears the errors of the timer flushes. The caller must hold the mutex.
+func (w *BatchWriter) takeErr() error {
+	err := w.err
+	w.err = nil
+	return err
+}
+
+// flush writes the batch and stops the flush timer. The caller must hold the mutex.
+func (w *BatchWriter) flush() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.batch) == 0 {
+		return nil
+	}
+	_, err := w.out.Write(w.batch)
+	w.batch = w.batch[:0]
+	return err
+}
@@ -0,0 +1,127 @@
+package extras
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// batchRecorder records the batches written to it, or fails with err if set.
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches []string
+	err     error
+}
+
+func (r *batchRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return 0, r.err
+	}
+	r.batches = append(r.batches, string(p))
+	return len(p), nil
+}
+
+func (r *batchRecorder) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+func (r *batchRecorder) written() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.batches...)
+}
+
+func TestBatchWriter(t *testing.T) {
+	out := &batchRecorder{}
+	w := NewBatchWriter(out, 10, 0)
+
+	for _, record := range []string{"aaa\n", "bbb\n"} {
+		n, err := w.Write([]byte(record))
+		assert.NoError(t, err)
+		assert.Equal(t, 4, n)
+	}
+	assert.Empty(t, out.written())
+
+	// The record doesn't fit: the batch is written first.
+	_, _ = w.Write([]byte("ccc\n"))
+	assert.Equal(t, []string{"aaa\nbbb\n"}, out.written())
+
+	// A record larger than the batch size is written on its own, after the current batch.
+	_, _ = w.Write([]byte("dddddddddd\n"))
+	assert.Equal(t, []string{"aaa\nbbb\n", "ccc\n", "dddddddddd\n"}, out.written())
+
+	// The partial batch is written on Close.
+	_, _ = w.Write([]byte("eee\n"))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, []string{"aaa\nbbb\n", "ccc\n", "dddddddddd\n", "eee\n"}, out.written())
+
+	_, err := w.Write([]byte("fff\n"))
+	assert.ErrorIs(t, err, ErrWriterClosed)
+}
+
+func TestBatchWriterReusesBuffer(t *testing.T) {
+	var out bytes.Buffer
+	w := NewBatchWriter(&out, 8, 0)
+
+	// The caller may reuse p after Write returns.
+	p := []byte("aaa\n")
+	_, _ = w.Write(p)
+	copy(p, "bbb\n")
+	_, _ = w.Write(p)
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, "aaa\nbbb\n", out.String())
+}
+
+func TestBatchWriterInterval(t *testing.T) {
+	out := &batchRecorder{}
+	w := NewBatchWriter(out, 1024, 10*time.Millisecond)
+	defer w.Close()
+
+	_, _ = w.Write([]byte("aaa\n"))
+	_, _ = w.Write([]byte("bbb\n"))
+	assert.Eventually(t, func() bool {
+		return len(out.written()) == 1
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []string{"aaa\nbbb\n"}, out.written())
+}
+
+func TestBatchWriterTimerError(t *testing.T) {
+	out := &batchRecorder{err: errors.New("unavailable")}
+	w := NewBatchWriter(out, 1024, 10*time.Millisecond)
+	defer w.Close()
+
+	_, _ = w.Write([]byte("aaa\n"))
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.err != nil
+	}, 5*time.Second, time.Millisecond)
+
+	// The error of the timer flush is not returned by Write, so the record isn't reported
+	// as failed, but by the next Flush.
+	out.setErr(nil)
+	n, err := w.Write([]byte("bbb\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.EqualError(t, w.Flush(), "unavailable")
+	assert.Equal(t, []string{"bbb\n"}, out.written())
+	assert.NoError(t, w.Flush())
+
+	// Close returns the error of the timer flush as well.
+	out.setErr(errors.New("unavailable"))
+	_, _ = w.Write([]byte("ccc\n"))
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.err != nil
+	}, 5*time.Second, time.Millisecond)
+	assert.EqualError(t, w.Close(), "unavailable")
+}
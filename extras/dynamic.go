@@ -0,0 +1,108 @@
+package extras
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// DynamicAttrsHandler is a [slog.Handler] wrapper that adds a mutable set of attributes to every
+// record, for long-lived connections or sessions that learn their context over time, such as
+// the user ID after authentication. Attributes set with SetDynamicAttr appear on the records
+// logged afterwards, without rebuilding the logger:
+//
+//	handler := extras.NewDynamicAttrsHandler(slogtfmt.NewHandler(os.Stderr, nil))
+//	logger := slog.New(handler)
+//	logger.Info("Connected")
+//	handler.SetDynamicAttr("user", "alice")
+//	logger.Info("Authenticated") // ... user="alice"
+//
+// The dynamic attributes are added after the attributes set with WithAttrs and before
+// the attributes of the record, in the order they were first set. Like the record attributes,
+// they are a part of the groups set with WithGroup. Handlers derived with WithAttrs and WithGroup
+// share the dynamic attributes.
+//
+// DynamicAttrsHandler is safe for concurrent use.
+type DynamicAttrsHandler struct {
+	handler slog.Handler
+	attrs   *dynamicAttrs
+}
+
+// dynamicAttrs is the attribute set shared by a DynamicAttrsHandler and its derived handlers.
+type dynamicAttrs struct {
+	mu    sync.RWMutex
+	attrs []slog.Attr
+}
+
+// NewDynamicAttrsHandler creates a new DynamicAttrsHandler that passes the records to handler
+// with the dynamic attributes added.
+func NewDynamicAttrsHandler(handler slog.Handler) *DynamicAttrsHandler {
+	return &DynamicAttrsHandler{handler: handler, attrs: &dynamicAttrs{}}
+}
+
+// SetDynamicAttr sets the value of the dynamic attribute with the given key. An attribute that
+// is already set keeps its position.
+func (h *DynamicAttrsHandler) SetDynamicAttr(key string, value any) {
+	a := slog.Any(key, value)
+	h.attrs.mu.Lock()
+	defer h.attrs.mu.Unlock()
+	for i := range h.attrs.attrs {
+		if h.attrs.attrs[i].Key == key {
+			h.attrs.attrs[i] = a
+			return
+		}
+	}
+	h.attrs.attrs = append(h.attrs.attrs, a)
+}
+
+// DeleteDynamicAttr removes the dynamic attribute with the given key, if it's set.
+func (h *DynamicAttrsHandler) DeleteDynamicAttr(key string) {
+	h.attrs.mu.Lock()
+	defer h.attrs.mu.Unlock()
+	for i := range h.attrs.attrs {
+		if h.attrs.attrs[i].Key == key {
+			h.attrs.attrs = slices.Delete(h.attrs.attrs, i, i+1)
+			return
+		}
+	}
+}
+
+// Enabled reports whether the wrapped handler handles records with the given level.
+func (h *DynamicAttrsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+// Handle passes a copy of the record with the dynamic attributes added to the wrapped handler.
+func (h *DynamicAttrsHandler) Handle(ctx context.Context, r slog.Record) error {
+	r2 := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	h.attrs.mu.RLock()
+	r2.AddAttrs(h.attrs.attrs...)
+	h.attrs.mu.RUnlock()
+	if r2.NumAttrs() == 0 {
+		return h.handler.Handle(ctx, r)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		r2.AddAttrs(a)
+		return true
+	})
+	return h.handler.Handle(ctx, r2)
+}
+
+// WithAttrs returns a new DynamicAttrsHandler that wraps the wrapped handler with the given
+// attributes and shares the dynamic attributes of h.
+func (h *DynamicAttrsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return &DynamicAttrsHandler{handler: h.handler.WithAttrs(attrs), attrs: h.attrs}
+}
+
+// WithGroup returns a new DynamicAttrsHandler that wraps the wrapped handler with the given
+// group and shares the dynamic attributes of h.
+func (h *DynamicAttrsHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &DynamicAttrsHandler{handler: h.handler.WithGroup(name), attrs: h.attrs}
+}
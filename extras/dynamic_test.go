@@ -0,0 +1,36 @@
+package extras
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/corvax/slogtfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDynamicAttrsHandler(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewDynamicAttrsHandler(slogtfmt.NewHandler(&buf, &slogtfmt.Options{TimeFormat: ""}))
+	logger := slog.New(handler).With("conn", 1)
+
+	logger.Info("Connected")
+	handler.SetDynamicAttr("user", "alice")
+	logger.Info("Authenticated", "method", "token")
+	handler.SetDynamicAttr("session", 42)
+	handler.SetDynamicAttr("user", "bob")
+	logger.Info("Switched user")
+	handler.DeleteDynamicAttr("user")
+	logger.Info("Logged out")
+
+	expected := "INFO\tConnected conn=1\n" +
+		"INFO\tAuthenticated conn=1 user=\"alice\" method=\"token\"\n" +
+		"INFO\tSwitched user conn=1 user=\"bob\" session=42\n" +
+		"INFO\tLogged out conn=1 session=42\n"
+	assert.Equal(t, expected, buf.String())
+
+	// The dynamic attributes are a part of the groups.
+	buf.Reset()
+	slog.New(handler).WithGroup("req").Info("Request", "path", "/")
+	assert.Equal(t, "INFO\tRequest req.session=42 req.path=\"/\"\n", buf.String())
+}
@@ -0,0 +1,186 @@
+package extras
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/corvax/slogtfmt"
+)
+
+// HTTPResponse describes the response to an HTTP request for HTTPRequestAttrs.
+type HTTPResponse struct {
+	// Status is the HTTP status code.
+	Status int
+	// Bytes is the number of bytes written in the response body.
+	Bytes int64
+	// Duration is the time taken to serve the request.
+	Duration time.Duration
+}
+
+// HTTPRequestAttrs returns attributes commonly used in access logs: method, path, remote_addr
+// and user_agent of the request and, if resp is not nil, status, bytes and duration of the response.
+// Empty request fields, such as a missing user agent, and a zero status are omitted.
+//
+//	logger.LogAttrs(ctx, slog.LevelInfo, "Request served", extras.HTTPRequestAttrs(r, &resp)...)
+func HTTPRequestAttrs(r *http.Request, resp *HTTPResponse) []slog.Attr {
+	attrs := make([]slog.Attr, 0, 7)
+	if r != nil {
+		attrs = appendNonEmpty(attrs, "method", r.Method)
+		if r.URL != nil {
+			attrs = appendNonEmpty(attrs, "path", r.URL.Path)
+		}
+		attrs = appendNonEmpty(attrs, "remote_addr", r.RemoteAddr)
+		attrs = appendNonEmpty(attrs, "user_agent", r.UserAgent())
+	}
+	if resp != nil {
+		if resp.Status != 0 {
+			attrs = append(attrs, slog.Int("status", resp.Status))
+		}
+		attrs = append(attrs, slog.Int64("bytes", resp.Bytes))
+		attrs = append(attrs, slog.Duration("duration", resp.Duration))
+	}
+	return attrs
+}
+
+func appendNonEmpty(attrs []slog.Attr, key, value string) []slog.Attr {
+	if value == "" {
+		return attrs
+	}
+	return append(attrs, slog.String(key, value))
+}
+
+// AccessLog returns an http.Handler middleware that serves the requests with next and logs
+// an access line for each request with the attributes of HTTPRequestAttrs, tagged [http]
+// unless the logger has tags:
+//
+//	http.ListenAndServe(addr, extras.AccessLog(logger, mux))
+//
+// The requests are logged at the info level. If next panics, the request is logged at the
+// error level with the panic value as the "panic" attribute. If nothing has been written yet,
+// the client receives a 500 Internal Server Error response; otherwise, the panic is propagated
+// as http.ErrAbortHandler to abort the partial response. The http.ErrAbortHandler panics are
+// always propagated. The requests whose connection was hijacked, e.g. for WebSocket, are logged
+// without the status, because the response is not written through the ResponseWriter.
+// The source of the access lines, with AddSource, is the location of the AccessLog call.
+//
+// The ResponseWriter passed to next implements http.Flusher, http.Hijacker and io.ReaderFrom,
+// and forwards them to the ResponseWriter of the server.
+func AccessLog(logger *slog.Logger, next http.Handler) http.Handler {
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:]) // skip [runtime.Callers, AccessLog]
+	pc := pcs[0]
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		ctx := slogtfmt.ContextWithTag(r.Context(), "http")
+
+		defer func() {
+			p := recover()
+			resp := &HTTPResponse{Status: sw.status, Bytes: sw.bytes, Duration: time.Since(start)}
+			written := sw.status != 0 || sw.hijacked
+			if p == nil {
+				if !written {
+					resp.Status = http.StatusOK
+				}
+				logAttrs(ctx, logger, slog.LevelInfo, pc, "Request served", HTTPRequestAttrs(r, resp))
+				return
+			}
+
+			if !written && p != http.ErrAbortHandler {
+				resp.Status = http.StatusInternalServerError
+				http.Error(w, http.StatusText(resp.Status), resp.Status)
+			}
+			attrs := append(HTTPRequestAttrs(r, resp), slog.String("panic", fmt.Sprint(p)))
+			logAttrs(ctx, logger, slog.LevelError, pc, "Request panicked", attrs)
+			if written || p == http.ErrAbortHandler {
+				// The partial response can't be completed, so abort it without another stack trace.
+				panic(http.ErrAbortHandler)
+			}
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// logAttrs logs a record with the given source program counter, because the records logged
+// with the Logger methods would have the source in AccessLog.
+func logAttrs(ctx context.Context, logger *slog.Logger, level slog.Level, pc uintptr, msg string, attrs []slog.Attr) {
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	r := slog.NewRecord(time.Now(), level, msg, pc)
+	r.AddAttrs(attrs...)
+	_ = logger.Handler().Handle(ctx, r)
+}
+
+// statusWriter is an http.ResponseWriter that records the status code and the number of
+// bytes of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush sends any buffered data to the client, if the underlying ResponseWriter supports it.
+func (w *statusWriter) Flush() {
+	if w.status == 0 && !w.hijacked {
+		w.status = http.StatusOK
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack lets the caller take over the connection, if the underlying ResponseWriter supports it.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// ReadFrom copies the response body from src, with the ReadFrom method of the underlying
+// ResponseWriter if it has one, e.g. to use sendfile.
+func (w *statusWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, src)
+	}
+	w.bytes += n
+	return n, err
+}
@@ -0,0 +1,176 @@
+package extras
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/corvax/slogtfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPRequestAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slogtfmt.NewHandler(&buf, &slogtfmt.Options{TimeFormat: ""}))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/users?id=1", nil)
+	r.Header.Set("User-Agent", "test-agent")
+	resp := &HTTPResponse{Status: http.StatusCreated, Bytes: 42, Duration: 1500 * time.Millisecond}
+
+	logger.LogAttrs(context.Background(), slog.LevelInfo, "Request served", HTTPRequestAttrs(r, resp)...)
+	expected := "INFO\tRequest served method=\"POST\" path=\"/api/users\" remote_addr=\"192.0.2.1:1234\" " +
+		"user_agent=\"test-agent\" status=201 bytes=42 duration=1.5s\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestHTTPRequestAttrsMissingFields(t *testing.T) {
+	r := &http.Request{Method: http.MethodGet}
+	attrs := HTTPRequestAttrs(r, nil)
+	assert.Equal(t, []slog.Attr{slog.String("method", "GET")}, attrs)
+
+	attrs = HTTPRequestAttrs(nil, &HTTPResponse{})
+	assert.Equal(t, []slog.Attr{slog.Int64("bytes", 0), slog.Duration("duration", 0)}, attrs)
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slogtfmt.NewHandler(&buf, &slogtfmt.Options{TimeFormat: ""}))
+	handler := AccessLog(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("created"))
+		case "/panic":
+			panic("boom")
+		default:
+			_, _ = w.Write([]byte("hello"))
+		}
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Regexp(t, `^INFO\t\[http\]\tRequest served method="GET" path="/hello" remote_addr="192.0.2.1:1234" status=200 bytes=5 duration=\S+\n$`, buf.String())
+
+	buf.Reset()
+	r = httptest.NewRequest(http.MethodPost, "/created", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Regexp(t, `^INFO\t\[http\]\tRequest served method="POST" path="/created" remote_addr="192.0.2.1:1234" status=201 bytes=7 duration=\S+\n$`, buf.String())
+
+	// The panics are logged at the error level and answered with a 500 response.
+	buf.Reset()
+	r = httptest.NewRequest(http.MethodGet, "/panic", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Regexp(t, `^ERROR\t\[http\]\tRequest panicked method="GET" path="/panic" remote_addr="192.0.2.1:1234" status=500 bytes=0 duration=\S+ panic="boom"\n$`, buf.String())
+
+	// The tags of the logger take precedence.
+	buf.Reset()
+	handler = AccessLog(logger.With(slogtfmt.Tag("api")), http.NotFoundHandler())
+	r = httptest.NewRequest(http.MethodGet, "/missing", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Regexp(t, `^INFO\t\[api\]\tRequest served method="GET" path="/missing" remote_addr="192.0.2.1:1234" status=404 bytes=19 duration=\S+\n$`, buf.String())
+}
+
+func TestAccessLogAbortHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slogtfmt.NewHandler(&buf, &slogtfmt.Options{TimeFormat: ""}))
+	handler := AccessLog(logger, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/abort", nil)
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	})
+	assert.Contains(t, buf.String(), "ERROR\t[http]\tRequest panicked")
+
+	// A panic after the response has been started aborts it instead of being swallowed.
+	buf.Reset()
+	handler = AccessLog(logger, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		panic("boom")
+	}))
+	w := httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(w, r)
+	})
+	assert.Equal(t, "partial", w.Body.String())
+	assert.Regexp(t, `^ERROR\t\[http\]\tRequest panicked method="GET" path="/abort" remote_addr="192.0.2.1:1234" status=200 bytes=7 duration=\S+ panic="boom"\n$`, buf.String())
+}
+
+// hijackRecorder is a ResponseRecorder that can be hijacked.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (w *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func TestAccessLogResponseWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slogtfmt.NewHandler(&buf, &slogtfmt.Options{TimeFormat: ""}))
+	var flushErr, hijackErr error
+	handler := AccessLog(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flush":
+			_, _ = w.Write([]byte("a"))
+			w.(http.Flusher).Flush()
+		case "/hijack":
+			_, _, hijackErr = w.(http.Hijacker).Hijack()
+		case "/copy":
+			_, _ = io.Copy(w, strings.NewReader("hello"))
+		}
+		flushErr = http.NewResponseController(w).Flush()
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/flush", nil))
+	assert.True(t, w.Flushed)
+	assert.NoError(t, flushErr)
+
+	// The hijacked connections are logged without the status.
+	buf.Reset()
+	hw := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(hw, httptest.NewRequest(http.MethodGet, "/hijack", nil))
+	assert.True(t, hw.hijacked)
+	assert.NoError(t, hijackErr)
+	assert.Regexp(t, `^INFO\t\[http\]\tRequest served method="GET" path="/hijack" remote_addr="192.0.2.1:1234" bytes=0 duration=\S+\n$`, buf.String())
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hijack", nil))
+	assert.ErrorIs(t, hijackErr, http.ErrNotSupported)
+
+	buf.Reset()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/copy", nil))
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Contains(t, buf.String(), " status=200 bytes=5 ")
+}
+
+func TestAccessLogSource(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slogtfmt.NewHandler(&buf, &slogtfmt.Options{TimeFormat: "", AddSource: true}))
+	_, _, line, _ := runtime.Caller(0)
+	handler := AccessLog(logger, http.NotFoundHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Contains(t, buf.String(), fmt.Sprintf("http_test.go:%d\t", line+1))
+}
@@ -0,0 +1,180 @@
+package extras
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrRecordsDropped is reported by ReconnectingWriter when buffered records are discarded
+// because the buffer is full or the writer is closed while disconnected.
+var ErrRecordsDropped = errors.New("extras: records dropped")
+
+// ErrWriterClosed is returned by ReconnectingWriter.Write and BatchWriter.Write after the writer is closed.
+var ErrWriterClosed = errors.New("extras: writer closed")
+
+// defaultRetryDelay is the minimum time between dial attempts of a ReconnectingWriter.
+const defaultRetryDelay = time.Second
+
+// ReconnectingWriter is an io.Writer that writes to a network connection, for example to ship logs
+// to a TCP sink, and reconnects when the connection breaks. Each Write call is treated as one record,
+// which matches how slogtfmt.Handler writes its output:
+//
+//	w := extras.NewReconnectingWriter(func() (net.Conn, error) {
+//		return net.DialTimeout("tcp", "logs.example.com:5140", 5*time.Second)
+//	}, 1<<20, func(err error) {
+//		fmt.Fprintln(os.Stderr, "log shipping:", err)
+//	})
+//	defer w.Close()
+//	handler := slogtfmt.NewHandler(w, nil)
+//
+// While the connection is down, records are buffered up to the given number of bytes, dropping
+// the oldest records first, and Write doesn't fail, so logging keeps working during outages.
+// The writer redials on Write at most once per second and sends the buffered records first
+// once connected. A record interrupted by a broken connection is sent again in full.
+//
+// ReconnectingWriter is safe for concurrent use.
+type ReconnectingWriter struct {
+	dial        func() (net.Conn, error)
+	maxBuffered int
+	onError     func(error)
+
+	mu          sync.Mutex
+	conn        net.Conn
+	pending     [][]byte
+	pendingSize int
+	nextDial    time.Time
+	closed      bool
+
+	retryDelay time.Duration
+	now        func() time.Time
+}
+
+// NewReconnectingWriter creates a new ReconnectingWriter that connects with dial and buffers
+// up to maxBuffered bytes of records while disconnected. The connection is established on the
+// first Write. If onError is not nil, it's called with the dial and write errors and with
+// ErrRecordsDropped when records are discarded, so persistent failures can be surfaced.
+// onError is called with the writer lock held and must not write to the writer.
+func NewReconnectingWriter(dial func() (net.Conn, error), maxBuffered int, onError func(error)) *ReconnectingWriter {
+	return &ReconnectingWriter{
+		dial:        dial,
+		maxBuffered: maxBuffered,
+		onError:     onError,
+		retryDelay:  defaultRetryDelay,
+		now:         time.Now,
+	}
+}
+
+// Write sends p to the connection, or buffers it if the writer is not connected.
+// It only fails if the writer is closed.
+func (w *ReconnectingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+	if w.connect() && w.flush() {
+		_, err := w.conn.Write(p)
+		if err == nil {
+			return len(p), nil
+		}
+		w.fail(err)
+	}
+	w.buffer(p)
+	return len(p), nil
+}
+
+// Close sends the buffered records if possible and closes the connection.
+// It returns an error wrapping ErrRecordsDropped if some buffered records could not be sent.
+func (w *ReconnectingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	var err error
+	if len(w.pending) > 0 {
+		w.nextDial = time.Time{}
+		if !w.connect() || !w.flush() {
+			err = fmt.Errorf("%w: %d records not sent", ErrRecordsDropped, len(w.pending))
+			w.pending, w.pendingSize = nil, 0
+		}
+	}
+	if w.conn != nil {
+		err = errors.Join(err, w.conn.Close())
+		w.conn = nil
+	}
+	return err
+}
+
+// connect dials a new connection if the writer is not connected and the retry delay has passed.
+// It reports whether the writer is connected.
+func (w *ReconnectingWriter) connect() bool {
+	if w.conn != nil {
+		return true
+	}
+	if w.now().Before(w.nextDial) {
+		return false
+	}
+	conn, err := w.dial()
+	if err != nil {
+		w.fail(err)
+		return false
+	}
+	w.conn = conn
+	return true
+}
+
+// flush sends the buffered records. It reports whether all of them were sent.
+func (w *ReconnectingWriter) flush() bool {
+	for len(w.pending) > 0 {
+		if _, err := w.conn.Write(w.pending[0]); err != nil {
+			w.fail(err)
+			return false
+		}
+		w.pendingSize -= len(w.pending[0])
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+	}
+	return true
+}
+
+// fail closes the broken connection, delays the next dial and reports the error.
+func (w *ReconnectingWriter) fail(err error) {
+	if w.conn != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+	w.nextDial = w.now().Add(w.retryDelay)
+	w.report(err)
+}
+
+// buffer stores a copy of p, dropping the oldest records if the buffer exceeds its size.
+func (w *ReconnectingWriter) buffer(p []byte) {
+	w.pending = append(w.pending, append([]byte(nil), p...))
+	w.pendingSize += len(p)
+
+	dropped := 0
+	for w.pendingSize > w.maxBuffered && len(w.pending) > 0 {
+		w.pendingSize -= len(w.pending[0])
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+		dropped++
+	}
+	if dropped > 0 {
+		w.report(fmt.Errorf("%w: buffer full, %d records discarded", ErrRecordsDropped, dropped))
+	}
+}
+
+// report passes the error to the error callback, if any.
+func (w *ReconnectingWriter) report(err error) {
+	if w.onError != nil {
+		w.onError(err)
+	}
+}
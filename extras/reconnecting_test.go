@@ -0,0 +1,171 @@
+package extras
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lineServer is a fake TCP log sink that accepts connections and collects the received lines.
+type lineServer struct {
+	ln    net.Listener
+	lines chan string
+}
+
+func newLineServer(t *testing.T) *lineServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	s := &lineServer{ln: ln, lines: make(chan string, 100)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					s.lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *lineServer) receive(t *testing.T, n int) []string {
+	var lines []string
+	for i := 0; i < n; i++ {
+		select {
+		case line := <-s.lines:
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d lines: %q", len(lines), n, lines)
+		}
+	}
+	return lines
+}
+
+func TestReconnectingWriter(t *testing.T) {
+	server := newLineServer(t)
+
+	var (
+		down atomic.Bool
+		mu   sync.Mutex
+		conn net.Conn
+		errs []error
+	)
+	dial := func() (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down.Load() {
+			return nil, errors.New("connection refused")
+		}
+		c, err := net.Dial("tcp", server.ln.Addr().String())
+		conn = c
+		return c, err
+	}
+	w := NewReconnectingWriter(dial, 1024, func(err error) {
+		errs = append(errs, err)
+	})
+	w.retryDelay = 0
+
+	n, err := w.Write([]byte("one\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []string{"one"}, server.receive(t, 1))
+
+	// Drop the connection and refuse new ones: the records are buffered.
+	down.Store(true)
+	mu.Lock()
+	_ = conn.Close()
+	mu.Unlock()
+	n, err = w.Write([]byte("two\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	_, err = w.Write([]byte("three\n"))
+	assert.NoError(t, err)
+	assert.Len(t, errs, 2) // the write error and the dial error
+
+	// Accept connections again: the buffered records are sent first.
+	down.Store(false)
+	_, err = w.Write([]byte("four\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"two", "three", "four"}, server.receive(t, 3))
+	assert.Len(t, errs, 2)
+
+	assert.NoError(t, w.Close())
+	_, err = w.Write([]byte("five\n"))
+	assert.ErrorIs(t, err, ErrWriterClosed)
+}
+
+func TestReconnectingWriterRetryDelay(t *testing.T) {
+	dials := 0
+	w := NewReconnectingWriter(func() (net.Conn, error) {
+		dials++
+		return nil, errors.New("connection refused")
+	}, 1024, nil)
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	_, _ = w.Write([]byte("one\n"))
+	_, _ = w.Write([]byte("two\n"))
+	assert.Equal(t, 1, dials)
+
+	now = now.Add(defaultRetryDelay)
+	_, _ = w.Write([]byte("three\n"))
+	assert.Equal(t, 2, dials)
+}
+
+func TestReconnectingWriterBufferLimit(t *testing.T) {
+	server := newLineServer(t)
+
+	var down atomic.Bool
+	down.Store(true)
+	var errs []error
+	w := NewReconnectingWriter(func() (net.Conn, error) {
+		if down.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return net.Dial("tcp", server.ln.Addr().String())
+	}, 10, func(err error) {
+		errs = append(errs, err)
+	})
+	w.retryDelay = 0
+
+	// The oldest records are dropped when the buffer is full.
+	for _, record := range []string{"aaa\n", "bbb\n", "ccc\n", "ddd\n"} {
+		_, err := w.Write([]byte(record))
+		assert.NoError(t, err)
+	}
+	var dropped int
+	for _, err := range errs {
+		if errors.Is(err, ErrRecordsDropped) {
+			dropped++
+		}
+	}
+	assert.Equal(t, 2, dropped)
+
+	down.Store(false)
+	_, _ = w.Write([]byte("eee\n"))
+	assert.Equal(t, []string{"ccc", "ddd", "eee"}, server.receive(t, 3))
+	assert.NoError(t, w.Close())
+
+	// Buffered records that can't be sent on Close are reported.
+	down.Store(true)
+	w = NewReconnectingWriter(func() (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}, 10, nil)
+	_, _ = w.Write([]byte("fff\n"))
+	assert.ErrorIs(t, w.Close(), ErrRecordsDropped)
+}
@@ -0,0 +1,96 @@
+package extras
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// RingWriter is an io.Writer that keeps the last records written to it in memory,
+// for example to expose the recent logs on a debug endpoint. Each Write call is stored
+// as one record, which matches how slogtfmt.Handler writes its output.
+// Use io.MultiWriter to keep the regular output as well:
+//
+//	ring := extras.NewRingWriter(1000)
+//	handler := slogtfmt.NewHandler(io.MultiWriter(os.Stderr, ring), nil)
+//	http.Handle("/debug/logs", ring)
+//
+// RingWriter is safe for concurrent use.
+type RingWriter struct {
+	mu      sync.Mutex
+	records [][]byte
+	next    int // index of the slot for the next record
+	full    bool
+}
+
+// NewRingWriter creates a new RingWriter that keeps up to size records.
+// It panics if size is less than 1.
+func NewRingWriter(size int) *RingWriter {
+	if size < 1 {
+		panic("extras: RingWriter size must be positive")
+	}
+	return &RingWriter{records: make([][]byte, size)}
+}
+
+// Write stores a copy of p as a record, evicting the oldest record if the ring is full.
+func (w *RingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.records[w.next] = append(w.records[w.next][:0], p...)
+	w.next++
+	if w.next == len(w.records) {
+		w.next = 0
+		w.full = true
+	}
+	return len(p), nil
+}
+
+// Records returns copies of the stored records, from the oldest to the newest.
+func (w *RingWriter) Records() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	records := make([]string, 0, len(w.records))
+	w.each(func(record []byte) {
+		records = append(records, string(record))
+	})
+	return records
+}
+
+// WriteTo writes the stored records to out, from the oldest to the newest.
+func (w *RingWriter) WriteTo(out io.Writer) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var total int64
+	var err error
+	w.each(func(record []byte) {
+		if err != nil {
+			return
+		}
+		var n int
+		n, err = out.Write(record)
+		total += int64(n)
+	})
+	return total, err
+}
+
+// ServeHTTP writes the stored records as plain text, from the oldest to the newest.
+func (w *RingWriter) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.WriteTo(rw)
+}
+
+// each calls fn for each stored record, from the oldest to the newest.
+// The caller must hold the mutex.
+func (w *RingWriter) each(fn func(record []byte)) {
+	if w.full {
+		for _, record := range w.records[w.next:] {
+			fn(record)
+		}
+	}
+	for _, record := range w.records[:w.next] {
+		fn(record)
+	}
+}
@@ -0,0 +1,52 @@
+package extras
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/corvax/slogtfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingWriter(t *testing.T) {
+	ring := NewRingWriter(3)
+	logger := slog.New(slogtfmt.NewHandler(ring, &slogtfmt.Options{TimeFormat: ""}))
+
+	logger.Info("first")
+	logger.Info("second")
+	assert.Equal(t, []string{"INFO\tfirst\n", "INFO\tsecond\n"}, ring.Records())
+
+	for i := 3; i <= 5; i++ {
+		logger.Info(fmt.Sprintf("record %d", i))
+	}
+	assert.Equal(t, []string{"INFO\trecord 3\n", "INFO\trecord 4\n", "INFO\trecord 5\n"}, ring.Records())
+
+	var buf bytes.Buffer
+	n, err := ring.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Equal(t, "INFO\trecord 3\nINFO\trecord 4\nINFO\trecord 5\n", buf.String())
+}
+
+func TestRingWriterServeHTTP(t *testing.T) {
+	ring := NewRingWriter(2)
+	_, _ = ring.Write([]byte("a\n"))
+	_, _ = ring.Write([]byte("b\n"))
+	_, _ = ring.Write([]byte("c\n"))
+
+	rec := httptest.NewRecorder()
+	ring.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/logs", nil))
+	assert.Equal(t, "b\nc\n", rec.Body.String())
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+}
+
+func TestRingWriterDoesNotRetainInput(t *testing.T) {
+	ring := NewRingWriter(2)
+	p := []byte("original")
+	_, _ = ring.Write(p)
+	copy(p, "modified")
+	assert.Equal(t, []string{"original"}, ring.Records())
+}
@@ -0,0 +1,90 @@
+// Package extras provides helpers that complement the slogtfmt handler,
+// such as io.Writer adapters for log output.
+package extras
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// TimestampingWriter is an io.Writer that prefixes each line with the time it started
+// being written, followed by a tab. It's useful for re-adding timestamps to output
+// that doesn't have them, for example a slogtfmt handler with an empty TimeFormat
+// or third-party writers.
+//
+// Writes that don't end with a newline are buffered until the line is complete
+// or Flush is called. TimestampingWriter is safe for concurrent use.
+type TimestampingWriter struct {
+	mu         sync.Mutex
+	out        io.Writer
+	timeFormat string
+	now        func() time.Time
+	line       []byte    // buffered partial line
+	lineStart  time.Time // time when the buffered partial line was started
+}
+
+// NewTimestampingWriter creates a new TimestampingWriter that writes to out,
+// formatting the timestamps with the given time.Format layout.
+func NewTimestampingWriter(out io.Writer, timeFormat string) *TimestampingWriter {
+	return &TimestampingWriter{
+		out:        out,
+		timeFormat: timeFormat,
+		now:        time.Now,
+	}
+}
+
+// Write writes the complete lines in p prefixed with timestamps
+// and buffers the trailing partial line, if any.
+func (w *TimestampingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	now := w.now()
+	var out []byte
+	for len(p) > 0 {
+		if len(w.line) == 0 {
+			w.lineStart = now
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.line = append(w.line, p...)
+			break
+		}
+		out = w.appendLine(out, p[:i+1])
+		p = p[i+1:]
+	}
+
+	if len(out) == 0 {
+		return n, nil
+	}
+	if _, err := w.out.Write(out); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Flush writes the buffered partial line, if any, prefixed with its timestamp.
+func (w *TimestampingWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.line) == 0 {
+		return nil
+	}
+	_, err := w.out.Write(w.appendLine(nil, nil))
+	return err
+}
+
+// appendLine appends the timestamp, the buffered partial line and the rest of the line to buf,
+// and clears the buffered partial line.
+func (w *TimestampingWriter) appendLine(buf, rest []byte) []byte {
+	buf = w.lineStart.AppendFormat(buf, w.timeFormat)
+	buf = append(buf, '\t')
+	buf = append(buf, w.line...)
+	buf = append(buf, rest...)
+	w.line = w.line[:0]
+	return buf
+}
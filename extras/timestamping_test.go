@@ -0,0 +1,55 @@
+package extras
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewTimestampingWriter(&buf, time.TimeOnly)
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	w.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	// A line split across several writes uses the time of its first write.
+	_, _ = w.Write([]byte("first "))
+	_, _ = w.Write([]byte("line\nsecond line\nthird"))
+	assert.Equal(t, "10:00:01\tfirst line\n10:00:02\tsecond line\n", buf.String())
+
+	n, err := w.Write([]byte(" line\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, "10:00:01\tfirst line\n10:00:02\tsecond line\n10:00:02\tthird line\n", buf.String())
+
+	// Flush writes the buffered partial line.
+	buf.Reset()
+	_, _ = w.Write([]byte("partial"))
+	assert.Empty(t, buf.String())
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, "10:00:04\tpartial", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, w.Flush())
+	assert.Empty(t, buf.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestTimestampingWriterError(t *testing.T) {
+	w := NewTimestampingWriter(failingWriter{}, time.TimeOnly)
+	n, err := w.Write([]byte("line\n"))
+	assert.True(t, errors.Is(err, io.ErrClosedPipe))
+	assert.Equal(t, 0, n)
+}
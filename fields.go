@@ -0,0 +1,93 @@
+package slogtfmt
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Fields is a reusable set of attributes built with a fluent API, for example:
+//
+//	fields := slogtfmt.Fields{}.Str("service", "api").Int("port", 8080)
+//	logger := slog.New(handler.WithAttrs(fields.Attrs()))
+//	logger.Info("Started", fields.Args()...)
+//
+// Every builder method returns a new Fields value and never modifies the receiver,
+// so a base set can be safely extended in different directions.
+type Fields []slog.Attr
+
+// add returns a copy of f with the given attribute appended.
+// The capacity is clipped to force a new backing array, so that the receiver is never modified.
+func (f Fields) add(attr slog.Attr) Fields {
+	return append(f[:len(f):len(f)], attr)
+}
+
+// Str adds a string attribute.
+func (f Fields) Str(key, value string) Fields {
+	return f.add(slog.String(key, value))
+}
+
+// Int adds an int attribute.
+func (f Fields) Int(key string, value int) Fields {
+	return f.add(slog.Int(key, value))
+}
+
+// Int64 adds an int64 attribute.
+func (f Fields) Int64(key string, value int64) Fields {
+	return f.add(slog.Int64(key, value))
+}
+
+// Uint64 adds an uint64 attribute.
+func (f Fields) Uint64(key string, value uint64) Fields {
+	return f.add(slog.Uint64(key, value))
+}
+
+// Float64 adds a float64 attribute.
+func (f Fields) Float64(key string, value float64) Fields {
+	return f.add(slog.Float64(key, value))
+}
+
+// Bool adds a bool attribute.
+func (f Fields) Bool(key string, value bool) Fields {
+	return f.add(slog.Bool(key, value))
+}
+
+// Time adds a time.Time attribute.
+func (f Fields) Time(key string, value time.Time) Fields {
+	return f.add(slog.Time(key, value))
+}
+
+// Duration adds a time.Duration attribute.
+func (f Fields) Duration(key string, value time.Duration) Fields {
+	return f.add(slog.Duration(key, value))
+}
+
+// Any adds an attribute with an arbitrary value.
+func (f Fields) Any(key string, value any) Fields {
+	return f.add(slog.Any(key, value))
+}
+
+// Tag adds a tag attribute, see [Tag].
+func (f Fields) Tag(name string) Fields {
+	return f.add(Tag(name))
+}
+
+// Group adds the attributes of the given Fields as a group with the given key.
+func (f Fields) Group(key string, group Fields) Fields {
+	return f.add(slog.Attr{Key: key, Value: slog.GroupValue(group...)})
+}
+
+// Attrs returns the attributes as a slice suitable for [slog.Handler.WithAttrs],
+// or [slog.Logger.LogAttrs].
+func (f Fields) Attrs() []slog.Attr {
+	return f[:len(f):len(f)]
+}
+
+// Args returns the attributes as a slice of any suitable for the variadic
+// [slog.Logger] methods, such as [slog.Logger.Info] and [slog.Logger.With].
+func (f Fields) Args() []any {
+	args := make([]any, len(f))
+	for i, a := range f {
+		args[i] = a
+	}
+	return args
+}
@@ -0,0 +1,77 @@
+package slogtfmt
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/corvax/slogtfmt/loggerf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFields(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandler(&buf, &Options{
+		TimeFormat: "",
+	})
+
+	fields := Fields{}.
+		Str("service", "api").
+		Int("port", 8080).
+		Bool("tls", true).
+		Duration("timeout", 5*time.Second)
+
+	logger := slog.New(handler.WithAttrs(fields.Attrs()))
+	logger.Info("Started")
+
+	expected := "INFO\tStarted service=\"api\" port=8080 tls=true timeout=5s\n"
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	slog.New(handler).Info("Started", fields.Args()...)
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestFieldsWithTagAndGroup(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandler(&buf, &Options{
+		TimeFormat: "",
+	})
+
+	fields := Fields{}.
+		Tag("db").
+		Str("host", "localhost").
+		Group("pool", Fields{}.Int("size", 10).Int("idle", 2))
+
+	logger := slog.New(handler).With(fields.Args()...)
+	logger.Info("Connected")
+
+	expected := "INFO\t[db]\tConnected host=\"localhost\" pool.size=10 pool.idle=2\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestFieldsDoNotModifyReceiver(t *testing.T) {
+	base := Fields{}.Str("a", "1").Str("b", "2")
+	f1 := base.Str("c", "3")
+	f2 := base.Str("d", "4")
+
+	assert.Len(t, base, 2)
+	assert.Equal(t, "c", f1[2].Key)
+	assert.Equal(t, "d", f2[2].Key)
+}
+
+func TestFieldsWithLoggerf(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandler(&buf, &Options{
+		TimeFormat: "",
+	})
+
+	fields := Fields{}.Str("user", "admin").Int("attempt", 3)
+	logger := loggerf.NewLogger(slog.New(handler).With(fields.Args()...))
+	logger.Logf(context.Background(), slog.LevelWarn, "Login failed for %s", "admin")
+
+	expected := "WARN\tLogin failed for admin user=\"admin\" attempt=3\n"
+	assert.Equal(t, expected, buf.String())
+}
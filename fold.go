@@ -0,0 +1,61 @@
+package slogtfmt
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// The message and the attribute keys of the summary record of the suppressed repeats.
+const (
+	foldSummaryMessage = "last message repeated"
+	suppressedCountKey = "suppressed_count"
+	windowKey          = "window"
+)
+
+// foldState tracks consecutive repeated records when FoldRepeats is set.
+type foldState struct {
+	last      []byte        // body of the last written record
+	level     slog.Level    // level of the last written record
+	repeats   int           // number of suppressed repeats of the last written record
+	firstTime time.Time     // time of the last written record
+	lastTime  time.Time     // time of the last suppressed repeat
+	window    time.Duration // time from the last written record to the last suppressed repeat
+	hasRecord bool
+}
+
+// repeated reports whether the record body is identical to the body of the previous record.
+// If it is, the repeat is counted. Otherwise the body is remembered for the next record.
+func (f *foldState) repeated(body []byte, r slog.Record) bool {
+	if f.hasRecord && string(f.last) == string(body) {
+		f.repeats++
+		f.lastTime = r.Time
+		if !f.firstTime.IsZero() && !r.Time.IsZero() {
+			f.window = r.Time.Sub(f.firstTime)
+		}
+		return true
+	}
+	f.last = append(f.last[:0], body...)
+	f.level = r.Level
+	f.firstTime = r.Time
+	f.hasRecord = true
+	return false
+}
+
+// writeFoldSummary writes the summary record of the suppressed repeats, if any.
+// The caller must hold the mutex.
+func (h *Handler) writeFoldSummary() error {
+	f := &h.state.fold
+	if f.repeats == 0 {
+		return nil
+	}
+	r := slog.NewRecord(f.lastTime, f.level, foldSummaryMessage, 0)
+	r.AddAttrs(slog.Int(suppressedCountKey, f.repeats), slog.Duration(windowKey, f.window))
+	f.repeats, f.window = 0, 0
+
+	// The summary is not a part of any group and doesn't carry the attributes of the handler.
+	root := *h
+	root.goas = nil
+	buf, _ := root.appendRecord(nil, context.Background(), r)
+	return h.write(buf, r.Level, r.Time)
+}
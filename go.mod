@@ -2,7 +2,10 @@ module github.com/corvax/slogtfmt
 
 go 1.22.1
 
-require github.com/stretchr/testify v1.9.0
+require (
+	github.com/stretchr/testify v1.9.0
+	google.golang.org/protobuf v1.36.5
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
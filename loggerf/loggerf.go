@@ -4,17 +4,50 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"runtime"
+	"sync"
+	"time"
 )
 
 // Logger wraps slog.Logger and adds formatted logging methods.
+// Copies of a Logger share the callbacks registered with OnLevel.
 type Logger struct {
 	*slog.Logger
+
+	hooks *hookRegistry
+}
+
+// hookRegistry holds the callbacks registered with OnLevel.
+type hookRegistry struct {
+	mu    sync.RWMutex
+	hooks []levelHook
+}
+
+// levelHook is a callback registered with OnLevel.
+type levelHook struct {
+	level slog.Level
+	fn    func()
 }
 
 // NewLogger creates a new Logger that wraps the provided slog.Logger.
 // The Logger provides formatted logging methods that delegate to the underlying slog.Logger.
 func NewLogger(logger *slog.Logger) *Logger {
-	return &Logger{logger}
+	return &Logger{Logger: logger, hooks: &hookRegistry{}}
+}
+
+// OnLevel registers a callback that is called after each formatted message logged at the given
+// level or above, for example to flush metrics, fire an alert or exit on the first error.
+// The callbacks are called in the order of registration, even if the level is disabled by the
+// handler. They apply to the formatted logging methods, such as Errorf, and not to the methods
+// of the embedded slog.Logger.
+func (l *Logger) OnLevel(level slog.Level, fn func()) {
+	if l.hooks == nil {
+		// The Logger was not created with NewLogger.
+		l.hooks = &hookRegistry{}
+	}
+	l.hooks.mu.Lock()
+	defer l.hooks.mu.Unlock()
+	l.hooks.hooks = append(l.hooks.hooks, levelHook{level: level, fn: fn})
 }
 
 // Logf logs a formatted message at the specified log level.
@@ -23,6 +56,70 @@ func (l *Logger) Logf(ctx context.Context, level slog.Level, format string, args
 	if l.Logger.Enabled(ctx, level) {
 		l.Logger.Log(ctx, level, fmt.Sprintf(format, args...))
 	}
+	l.runHooks(level)
+}
+
+// LogfAttrs logs a formatted message with the given attributes at the specified log level.
+func (l *Logger) LogfAttrs(ctx context.Context, level slog.Level, format string, attrs []slog.Attr, args ...any) {
+	// Check if the logger is enabled to avoid unnecessary calls of fmt.Sprintf().
+	if l.Logger.Enabled(ctx, level) {
+		l.Logger.LogAttrs(ctx, level, fmt.Sprintf(format, args...), attrs...)
+	}
+	l.runHooks(level)
+}
+
+// LogfFunc logs a formatted message at the specified log level, with the arguments returned by
+// the given functions. The functions are only called if the level is enabled, so expensive
+// arguments are not computed for disabled levels:
+//
+//	logger.LogfFunc(ctx, slog.LevelDebug, "cache: %s", cache.Dump)
+func (l *Logger) LogfFunc(ctx context.Context, level slog.Level, format string, argFuncs ...func() any) {
+	if l.Logger.Enabled(ctx, level) {
+		args := make([]any, len(argFuncs))
+		for i, f := range argFuncs {
+			args[i] = f()
+		}
+		l.Logger.Log(ctx, level, fmt.Sprintf(format, args...))
+	}
+	l.runHooks(level)
+}
+
+// runHooks calls the callbacks registered for the level.
+func (l *Logger) runHooks(level slog.Level) {
+	if l.hooks == nil {
+		return
+	}
+	l.hooks.mu.RLock()
+	hooks := l.hooks.hooks
+	l.hooks.mu.RUnlock()
+	for _, hook := range hooks {
+		if level >= hook.level {
+			hook.fn()
+		}
+	}
+}
+
+// Span starts timing an operation and returns a function that logs the message with the given
+// attributes and an "elapsed" duration attribute at the info level when called, typically deferred:
+//
+//	defer logger.Span("sync users", "source", "ldap")()
+//
+// The source of the record is the Span call.
+func (l *Logger) Span(msg string, args ...any) func() {
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:]) // skip [runtime.Callers, Span]
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		ctx := context.Background()
+		if !l.Logger.Enabled(ctx, slog.LevelInfo) {
+			return
+		}
+		r := slog.NewRecord(time.Now(), slog.LevelInfo, msg, pcs[0])
+		r.Add(args...)
+		r.AddAttrs(slog.Duration("elapsed", elapsed))
+		_ = l.Logger.Handler().Handle(ctx, r)
+	}
 }
 
 // Infof logs a formatted info message.
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"testing"
+	"time"
 
 	"github.com/corvax/slogtfmt"
 	"github.com/stretchr/testify/assert"
@@ -91,3 +92,120 @@ func TestLoggerf_Args(t *testing.T) {
 	// t.Log(buf.String())
 	assert.Equal(t, expected, buf.String())
 }
+
+func TestLoggerf_LogfAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slogtfmt.NewHandler(&buf, &slogtfmt.Options{
+		Level:      slog.LevelInfo,
+		TimeFormat: "",
+	})
+	logger := NewLogger(slog.New(handler).With(slogtfmt.Tag("auth")).WithGroup("req"))
+
+	attrs := []slog.Attr{slog.String("user", "admin"), slog.Int("attempt", 3)}
+	logger.LogfAttrs(context.Background(), slog.LevelWarn, "Login failed for %s from %s", attrs, "admin", "localhost")
+
+	expected := "WARN\t[auth]\tLogin failed for admin from localhost req.user=\"admin\" req.attempt=3\n"
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	logger.LogfAttrs(context.Background(), slog.LevelDebug, "Debug %s", attrs, "message")
+	assert.Empty(t, buf.String())
+}
+
+func TestLoggerf_LogfFunc(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slogtfmt.NewHandler(&buf, &slogtfmt.Options{
+		Level:      slog.LevelInfo,
+		TimeFormat: "",
+	})
+	logger := NewLogger(slog.New(handler))
+
+	calls := 0
+	expensive := func() any {
+		calls++
+		return "dump"
+	}
+	logger.LogfFunc(context.Background(), slog.LevelDebug, "State: %s", expensive)
+	assert.Empty(t, buf.String())
+	assert.Zero(t, calls)
+
+	logger.LogfFunc(context.Background(), slog.LevelInfo, "State: %s, size: %d", expensive, func() any { return 42 })
+	assert.Equal(t, "INFO\tState: dump, size: 42\n", buf.String())
+	assert.Equal(t, 1, calls)
+}
+
+func TestLoggerSpan(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slogtfmt.NewHandlerWithOptions(&buf,
+		slogtfmt.WithTimeFormat(""),
+		slogtfmt.WithAddSource(true),
+		slogtfmt.WithDurationFormat(slogtfmt.DurationSeconds),
+	)
+	logger := NewLogger(slog.New(handler))
+
+	func() {
+		defer logger.Span("operation", "id", 7)()
+		time.Sleep(20 * time.Millisecond)
+	}()
+
+	var (
+		source  string
+		elapsed float64
+	)
+	_, err := fmt.Sscanf(buf.String(), "INFO\t%s\toperation id=7 elapsed=%g\n", &source, &elapsed)
+	assert.NoError(t, err, buf.String())
+	assert.Contains(t, source, "loggerf_test.go:")
+	assert.GreaterOrEqual(t, elapsed, 0.02)
+	assert.Less(t, elapsed, 1.0)
+
+	// Nothing is logged if the info level is disabled.
+	buf.Reset()
+	logger = NewLogger(slog.New(slogtfmt.NewHandlerWithOptions(&buf, slogtfmt.WithLevel(slog.LevelWarn))))
+	logger.Span("operation")()
+	assert.Empty(t, buf.String())
+}
+
+func TestLoggerOnLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(slog.New(slogtfmt.NewHandler(&buf, &slogtfmt.Options{TimeFormat: ""})))
+
+	var calls []string
+	logger.OnLevel(slog.LevelError, func() {
+		// The callback runs after the message is written.
+		calls = append(calls, "error hook: "+buf.String())
+	})
+	logger.OnLevel(slog.LevelWarn, func() {
+		calls = append(calls, "warn hook")
+	})
+
+	logger.Infof("info %d", 1)
+	logger.Debugf("debug %d", 2)
+	assert.Empty(t, calls)
+
+	logger.Warnf("warn %d", 3)
+	assert.Equal(t, []string{"warn hook"}, calls)
+
+	calls = nil
+	buf.Reset()
+	logger.Errorf("error %d", 4)
+	assert.Equal(t, []string{"error hook: ERROR\terror 4\n", "warn hook"}, calls)
+
+	calls = nil
+	logger.LogfAttrs(context.Background(), slog.LevelError+4, "fatal", nil)
+	assert.Len(t, calls, 2)
+
+	// Copies of the Logger share the callbacks.
+	calls = nil
+	copied := *logger
+	copied.Warnf("warn %d", 5)
+	assert.Equal(t, []string{"warn hook"}, calls)
+
+	// A Logger not created with NewLogger has no callbacks until one is registered.
+	calls = nil
+	literal := &Logger{Logger: logger.Logger}
+	literal.Warnf("warn %d", 6)
+	assert.Empty(t, calls)
+	literal.OnLevel(slog.LevelWarn, func() { calls = append(calls, "literal hook") })
+	literal.Warnf("warn %d", 7)
+	assert.Equal(t, []string{"literal hook"}, calls)
+}
@@ -2,11 +2,27 @@ package slogtfmt
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"log/slog"
+	"maps"
+	"math"
+	"net/url"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Options struct {
@@ -30,21 +46,656 @@ type Options struct {
 	// in the log record. If empty, the default time format of time.RFC3339 is used.
 	TimeAttributeFormat string
 
+	// TimeAttributeOmitZone removes the time zone elements, such as "Z07:00" or "MST",
+	// from TimeAttributeFormat, so time attributes have a fixed zone-less format
+	// regardless of the layout. Combine it with TimeAttributeInUTC to get UTC times
+	// without the "Z" suffix.
+	TimeAttributeOmitZone bool
+
 	// TimeAttributeInUTC specifies whether the time attribute in the log record
 	// should use UTC instead of the local time zone.
 	TimeAttributeInUTC bool
+
+	// DurationFormat specifies how time.Duration attribute values are rendered.
+	// If not set, [DurationString] is used.
+	DurationFormat DurationFormat
+
+	// ConstantAttrs are attributes added to every log record right after the message.
+	// Unlike attributes added with WithAttrs, they are formatted once when the Handler
+	// is created, so they are cheap to include on every record. They are not affected
+	// by groups.
+	ConstantAttrs []slog.Attr
+
+	// PartitionToken derives a partition token, such as the date, from the record time.
+	// If set and the output writer implements [PartitionWriter], each formatted record
+	// is written with WritePartition along with its token, so the writer can route records,
+	// for example to daily log files. The record time is passed in UTC if TimeInUTC is set.
+	// The token is not included in the output.
+	PartitionToken func(t time.Time) string
+
+	// HeaderSeparator is the separator between the segments preceding the message:
+	// the timestamp, the level, the tag and the source. The message is always preceded
+	// by a single tab, so setting HeaderSeparator to a space keeps exactly one tab before
+	// the message regardless of which optional segments are present.
+	// If empty, a tab is used.
+	HeaderSeparator string
+
+	// Vocabulary defines the literal words used for bool and nil attribute values.
+	// Empty fields use the default words.
+	Vocabulary Vocabulary
+
+	// TypeFormatters are consulted in order for attribute values of kind slog.KindAny.
+	// The value returned by the first formatter that handles the value is rendered instead.
+	// They allow compact rendering of types whose default formatting is not suitable
+	// for logs, such as protocol buffer messages.
+	TypeFormatters []TypeFormatter
+
+	// AddChecksum causes the handler to append a checksum=<crc32> field as the last field
+	// of each record. The checksum is the CRC-32 (IEEE) of the line content preceding
+	// " checksum=", in hexadecimal, and allows consumers to detect corrupted or truncated lines.
+	AddChecksum bool
+
+	// LevelFormat specifies how the record level is rendered.
+	// If not set, [LevelText] is used.
+	LevelFormat LevelFormat
+
+	// KeyValueSeparator is the separator between attribute keys and values.
+	// If empty, "=" is used.
+	KeyValueSeparator string
+
+	// SourceMinLevel is the minimum level of records that include the source
+	// when AddSource is set. Records with lower levels skip the source lookup.
+	// The levels are compared with LevelComparator, if set.
+	// If nil, the source is included for all levels.
+	SourceMinLevel slog.Leveler
+
+	// SourceFrames is the number of stack frames included in the source when AddSource is set.
+	// If greater than 1, the source is a semicolon-separated chain of frames starting at the
+	// log statement and walking up the call stack, e.g. "a.go:10;b.go:20".
+	// The chain is limited to MaxSourceFrames frames and may be shorter if the stack is not
+	// as deep or the Handler is not called synchronously from the log statement.
+	SourceFrames int
+
+	// ExpandErrorChain causes error attribute values to be rendered as quoted strings
+	// followed by a <key>.cause attribute with the innermost error of the chain
+	// unwrapped with errors.Unwrap, if the error wraps other errors.
+	ExpandErrorChain bool
+
+	// FoldRepeats causes the handler to fold consecutive records that are identical
+	// except for the timestamp: instead of writing the repeated records, it counts them
+	// and writes a "last message repeated" summary record when a different record
+	// is logged or the Handler is closed. The summary has the number of repeats in
+	// the suppressed_count attribute and the time from the first record to the last
+	// repeat in the window attribute, e.g. suppressed_count=3 window=3s.
+	FoldRepeats bool
+
+	// TabularAttrs causes the handler to pad attribute values with spaces to the widest value
+	// recently seen for the same key, so that attributes of consecutive similar records line up
+	// in columns. The widths adapt over a window of recent records, so the alignment is eventually
+	// consistent. It's intended for local development; records are formatted under the handler
+	// mutex in this mode.
+	TabularAttrs bool
+
+	// LevelLabels maps levels to custom names, for example to name custom levels
+	// such as slog.LevelInfo+2 "NOTICE". Levels not in the map use their slog names.
+	LevelLabels map[slog.Level]string
+
+	// PriorityKeys are attribute keys that are written before all other attributes,
+	// in the given order, for example "request_id" or "error". Keys of grouped attributes
+	// include the group prefix, e.g. "http.status". The other attributes follow in their
+	// original order. Constant attributes are not reordered and precede all others.
+	PriorityKeys []string
+
+	// FramingMode specifies how records are delimited in the output.
+	// If not set, [FramingNewline] is used.
+	FramingMode FramingMode
+
+	// AddRecordID causes the handler to append a record_id=<hash> field with a 64-bit FNV-1a hash
+	// of the level, the message and the attributes, excluding the time. Identical events get
+	// identical IDs, so downstream systems can deduplicate retries of the same logical event.
+	// Enabling it forces the attributes to be sorted by key (after the PriorityKeys, if any),
+	// so the ID doesn't depend on the order in which the attributes were added.
+	// Unlike AddChecksum, it's not meant to detect corrupted lines. The ID covers the rendered
+	// attributes, so it's not stable when combined with TabularAttrs padding.
+	AddRecordID bool
+
+	// SourceRoot makes the source file paths relative to the given root directory,
+	// for example the module root of a monorepo, yielding "internal/db/conn.go:42".
+	// Paths outside of the root are written in full. If empty, the full paths are used.
+	SourceRoot string
+
+	// FloatSpecials specifies how NaN and infinite float attribute values are rendered.
+	// If not set, [FloatSpecialsLiteral] is used.
+	FloatSpecials FloatSpecials
+
+	// LevelFunc returns the minimum level to log at the given time, for example to log more
+	// verbosely during business hours. If set, it's used instead of Level: Enabled calls it
+	// with the current time and Handle with the record time, so it's called at least twice
+	// per record and must be cheap and safe for concurrent use. A level set by [ContextWithLevel]
+	// still takes precedence.
+	LevelFunc func(t time.Time) slog.Level
+
+	// TrimMessage causes the handler to remove the leading and trailing white space,
+	// including newlines, from the record message. White space inside the message is kept.
+	TrimMessage bool
+
+	// WriteTimeout is the maximum time Handle waits for the output writer, so a slow or stalled
+	// sink can't block the logging goroutines indefinitely. If set, each record is written by
+	// a new goroutine and Handle returns [ErrWriteTimeout] if the write doesn't complete in time.
+	// The write is not canceled: records are dropped with ErrWriteTimeout until the timed out
+	// write returns, so the output is never interleaved. [Handler.Close] waits for it up to the
+	// WriteTimeout. It costs a goroutine and a copy of the record per write. If zero, Handle
+	// waits for the writer without a limit.
+	WriteTimeout time.Duration
+
+	// SafeRunes reports whether a rune can appear in an unquoted string value. If set, string values
+	// that consist only of safe runes are written without quotes, e.g. url=https://example.com/a
+	// when '/', ':' and '.' are safe. Empty strings and strings with spaces, quotes, backslashes
+	// or non-printable runes are always quoted. If nil, all string values are quoted.
+	SafeRunes func(r rune) bool
+
+	// TagMode specifies how the tags set with [Tag] are rendered.
+	// If not set, [TagBracket] is used.
+	TagMode TagMode
+
+	// TagKey is the attribute key of the tags when TagMode renders them as attributes.
+	// If empty, "tag" is used.
+	TagKey string
+
+	// StrictKeys causes Handle to reject records with malformed attribute keys: non-group
+	// attributes with an empty key, and attribute keys and group names containing the group
+	// separator, which make the output ambiguous, unless EscapeKeys is set. Such records are not written and Handle
+	// returns an error wrapping [ErrInvalidKey]. Note that slog.Logger ignores the errors
+	// of the Handler, so it's intended for development and tests. The attributes added with
+	// WithAttrs are resolved and checked once by WithAttrs.
+	StrictKeys bool
+
+	// AttrsBrackets causes the handler to wrap the attributes in AttrsDelimiters to separate them
+	// from the message, e.g. "msg {k1=v1 k2=v2}". Records without attributes have no brackets.
+	AttrsBrackets bool
+
+	// AttrsDelimiters are the opening and closing delimiters of the attributes when AttrsBrackets is set.
+	// Empty delimiters are replaced with "{" and "}".
+	AttrsDelimiters [2]string
+
+	// Component is the name of the application component, written as a column right after
+	// the level, before the tags. Unlike the tags, it's set for the Handler, for example with
+	// WithOptions(WithComponent("db")), and padded to ComponentWidth. If empty, the column is omitted.
+	Component string
+
+	// ComponentWidth is the minimum width of the Component column in runes. Shorter names
+	// are padded with spaces, so the columns of different components line up.
+	ComponentWidth int
+
+	// AddElapsed causes the handler to write the time elapsed since the Handler was created
+	// after the timestamp, formatted according to DurationFormat, e.g. "1.234567ms\tINFO\tmsg".
+	// It uses the monotonic clock, so it's useful when the timestamps are too coarse or omitted.
+	// Like the timestamp, it's not a part of the record compared by FoldRepeats or AddRecordID.
+	AddElapsed bool
+
+	// KeyAliases renames attribute keys on output, for example {"userId": "user_id"} to migrate
+	// between naming conventions without touching the call sites. The aliases apply to the keys
+	// of attributes and groups, including the groups added with WithGroup, without the group
+	// prefix, so "req.userId" becomes "req.user_id".
+	// Attributes renamed to an existing key are written along with it, in their original order.
+	KeyAliases map[string]string
+
+	// InferUnits causes the handler to append units to numeric attribute values whose keys end
+	// with a known unit suffix, e.g. "latency_ms=42ms". The suffixes and units are set by UnitSuffixes.
+	// No unit is appended to nil values, NaN and the infinities.
+	InferUnits bool
+
+	// UnitSuffixes maps key suffixes to the units appended to the values when InferUnits is set.
+	// The longest matching suffix is used. If nil, [DefaultUnitSuffixes] is used.
+	UnitSuffixes map[string]string
+
+	// EmitEmpty causes the handler to write group attributes without members, which are dropped
+	// by default, as "key={}", so it's visible that a field was intentionally blank. Such groups
+	// come from LogValuers or WithAttrs, since slog drops empty groups created with slog.Group.
+	// Empty string values are always written as key="". Attributes without a key and value
+	// are still dropped.
+	EmitEmpty bool
+
+	// OnRecord is called after each record is successfully written, with the record level
+	// and the number of bytes written, for example to count the logged records by level.
+	// It's not called for records that fail to be written. It's called with the handler
+	// mutex held, so it must be cheap and must not log with the same Handler.
+	OnRecord func(level slog.Level, n int)
+
+	// RelativeTimeAttrs causes time attribute values to be rendered relative to the current time
+	// in the largest whole unit, e.g. created="3s ago" or expires="in 5m", for human-facing output.
+	// Zero times are rendered in the TimeAttributeFormat.
+	RelativeTimeAttrs bool
+
+	// Clock returns the current time used by RelativeTimeAttrs. If nil, time.Now is used.
+	Clock func() time.Time
+
+	// KeyNamespace is prepended to all attribute keys after the group prefix, e.g. "svc." makes
+	// "req.status" "svc.req.status". Unlike a group set with WithGroup, it applies to all
+	// attributes, including the constant attributes. PriorityKeys don't include it.
+	KeyNamespace string
+
+	// FullyKeyed causes the handler to write the header segments as key=value fields, so the whole
+	// line is logfmt without positional segments. The fields are written in the following order:
+	// time, elapsed (with AddElapsed), delta (with DeltaTimestamps), level, component, the tags with the TagKey, source, msg
+	// and the attributes, separated by single spaces. The message is always quoted, and the other
+	// header values are quoted if needed. HeaderSeparator and the padding of the component
+	// are not used, and the tags are written as header fields regardless of TagMode.
+	FullyKeyed bool
+
+	// LevelComparator reports whether records with the given level are logged at the threshold
+	// level set by Level, LevelFunc or [ContextWithLevel], for level schemes where a higher level
+	// is not more severe. If nil, records with levels greater than or equal to the threshold are logged.
+	LevelComparator func(level, threshold slog.Level) bool
+
+	// SeparateTags causes the handler to write TagSeparator before a record whose tags differ
+	// from the tags of the previous record, so the output of interleaved subsystems is easier
+	// to read during local development. It's not suitable for FramingLengthPrefix.
+	SeparateTags bool
+
+	// TagSeparator is written between records with different tags when SeparateTags is set.
+	// If empty, a newline is used, which results in a blank line.
+	TagSeparator string
+
+	// BuildInfo causes the handler to add a "build" group with the version of the main module
+	// and the VCS revision it was built from, if known, to every record after the constant
+	// attributes, e.g. build.version="v1.2.3" build.revision="4f2c1ab", to correlate the logs with
+	// deployments. The build info is read once per process. If it's not available, as in test
+	// binaries, no attribute is added.
+	BuildInfo bool
+
+	// SortAttrs specifies how the attributes of the record and of the handler are sorted.
+	// The constant attributes are not sorted, and PriorityKeys are still written first.
+	// If not set, the attributes keep their original order.
+	SortAttrs SortMode
+
+	// FloatPrecision is the number of digits after the decimal point of float attribute values
+	// and DurationSeconds durations, e.g. 2 renders 3.14159 as 3.14, for fixed-width columns and
+	// golden tests. The values are rounded half to even on their exact binary value, so 1.005
+	// renders as 1.00. If zero, the shortest representation that preserves the value is used.
+	// Either way, the output only depends on the value: it's byte-identical across platforms
+	// and locales.
+	FloatPrecision int
+
+	// GroupSeparator separates the group names and the attribute key in the keys of grouped
+	// attributes, e.g. "/" makes "req.status" "req/status". If empty, "." is used.
+	GroupSeparator string
+
+	// EscapeKeys causes the handler to escape the GroupSeparator and backslashes in attribute
+	// keys and group names with a backslash, so parsers can tell the group boundaries from
+	// literal separators, e.g. the key "a.b" in the group "g" is written as g.a\.b.
+	// PriorityKeys must be written in the escaped form.
+	EscapeKeys bool
+
+	// OnError is called with the errors that don't fail the logging call, such as a panic in
+	// the LogValue method of an attribute value, an attribute TypeFormatter or the Error method
+	// of an error value. Such values are written as !PANIC(<panic value>) placeholders instead of
+	// letting the panic propagate out of Handle. It's called with the Handler mutex held if
+	// TabularAttrs is set, so it must not log with the same Handler.
+	OnError func(error)
+
+	// MaxGroupDepth is the maximum nesting depth of the groups in attribute values, including
+	// the groups without a key. Deeper groups are written as a {...} placeholder, e.g.
+	// a.b={...} for the limit of 1, so deeply nested or recursive values, such as a LogValuer
+	// returning a group containing itself, can't exhaust the stack. The placeholder of a deeper
+	// group without a key is written under the key of the enclosing group, e.g. a={...}.
+	// The groups set with WithGroup don't count. If zero, the depth is not limited.
+	MaxGroupDepth int
+
+	// DeltaTimestamps causes the handler to write the time since the previous record after
+	// the timestamp and the elapsed time, formatted according to DurationFormat with a sign,
+	// e.g. "+3ms\tINFO\tmsg", to spot slow steps at a glance. The first record has a delta of +0s.
+	// The delta is computed from the record times; the records without a time use Clock.
+	// It's tracked across the Handler and the handlers derived from it, and FormatRecord doesn't
+	// change it. Like the timestamp, it's not a part of the record compared by FoldRepeats or
+	// AddRecordID.
+	DeltaTimestamps bool
+
+	// HeaderLine causes NewHandler to write a comment line describing the header columns
+	// of the created Handler, e.g. "# columns: time level tag source message", so generic
+	// parsers can split the positional format. The columns are listed by [Handler.Columns]
+	// and don't reflect the LevelOptions. If the write fails, the error is passed to OnError
+	// and the line is written again before the first record. It's not written in the
+	// FullyKeyed mode, whose lines describe themselves, and it's not suitable for FramingLengthPrefix.
+	HeaderLine bool
+
+	// LevelOptions are options applied on top of the other options to the records with the given
+	// level or higher, up to the next level in the map, e.g. to add the source only to errors:
+	//
+	//	LevelOptions: map[slog.Level][]Option{slog.LevelError: {WithAddSource(true)}}
+	//
+	// The options of the highest level not above the record level are used; with LevelComparator,
+	// the options of the most severe level at which the record would be logged. They don't change
+	// which records are logged: Level, LevelFunc and LevelComparator are not overridden.
+	LevelOptions map[slog.Level][]Option
+
+	// PositionalKeys are attribute keys whose values are written positionally without the keys,
+	// in the given order, for compact fixed-schema lines, e.g. the keys "status" and "path"
+	// turn msg status=200 path="/a" into msg 200 "/a". Missing attributes are written as "",
+	// so the columns stay aligned. The other attributes are written after them with their keys. Keys of grouped
+	// attributes include the group prefix, like PriorityKeys. The values are formatted like the
+	// keyed ones, e.g. with InferUnits, and with ExpandErrorChain the cause of an error value
+	// is written first among the other attributes.
+	PositionalKeys []string
+
+	// JoinTags is the separator used to join the tags of a logger built in several With layers
+	// into a single tag, e.g. "/" renders With(Tag("api")).With(Tag("v2")) as [api/v2] instead
+	// of [api] and [v2]. If empty, the tags are rendered separately.
+	JoinTags string
+
+	// SourceAsURI causes the handler to write the source file as a file URI, e.g.
+	// "file:///home/me/app/main.go:42", which terminals and editors can open with a click.
+	// Relative paths, such as those of binaries built with -trimpath, are made absolute with
+	// filepath.Abs, and special characters, such as spaces, are percent-encoded.
+	// SourceRoot is not used.
+	SourceAsURI bool
+
+	// DedupKeys causes the handler to write only the last of the attributes with the same key,
+	// including the group prefix, so a record attribute replaces an attribute with the same key
+	// added with WithAttrs, and a later WithAttrs attribute replaces an earlier one.
+	// The constant attributes are not deduplicated.
+	DedupKeys bool
+
+	// WarnShadowedKeys causes the handler to call OnError with an error wrapping [ErrShadowedKey]
+	// when a record attribute replaces an attribute added with WithAttrs, to catch accidental
+	// key collisions during development. It's reported once per key for the Handler and the handlers
+	// derived from it. It requires DedupKeys.
+	WarnShadowedKeys bool
+
+	// EndMarker is written by the first successful Close call after the pending output, so consumers
+	// of the log stream can tell a clean shutdown from a truncated stream. It's written as is,
+	// so it should include the line terminator, e.g. "--- end of log ---\n", except that with
+	// FramingLengthPrefix and FramingLengthPrefixNewline it's prefixed with its length like a record.
+	// It's not a record, so it's not passed to OnRecord. If empty, nothing is written.
+	EndMarker string
+
+	// AutoTagFromPackage adds a tag with the name of the package of the caller, e.g. [http],
+	// to the records that have a program counter and no tag set by Tag or ContextWithTag,
+	// which take precedence. The package name is the last element of the import path.
+	AutoTagFromPackage bool
+
+	// FallbackHandler receives the records that the Handler fails to log, because they have
+	// malformed keys with StrictKeys or writing them fails, so they aren't lost entirely, e.g.
+	// a slog.TextHandler writing to os.Stderr. The attributes and groups of the Handler are
+	// added to it, with the tags as attributes with the TagKey. The records are forwarded at
+	// most once, so a fallback that logs back to the Handler can't recurse. Handle returns
+	// the error of the Handler joined with the error of the fallback, if any.
+	FallbackHandler slog.Handler
+
+	// MultilineAttrs writes each attribute on its own continuation line indented with a tab,
+	// instead of on the record line. It breaks the one line per record layout, so it's meant
+	// to be set for the error levels only with LevelOptions, for readable error diagnostics
+	// while the other records stay compact:
+	//
+	//	WithLevelOptions(slog.LevelError, WithMultilineAttrs(true))
+	//
+	// The values of the PositionalKeys are written on their own lines as well. TabularAttrs is ignored.
+	MultilineAttrs bool
+
+	// OpaqueValues writes the attribute values of func, channel and unsafe.Pointer types as
+	// the placeholders func(...), chan(...) and unsafe.Pointer(...) instead of their addresses,
+	// which are meaningless in logs. Their String and Error methods are not called. Custom
+	// placeholders can be rendered with TypeFormatters, which are consulted first.
+	OpaqueValues bool
+
+	// StatsInterval is the interval at which a "handler stats" record with the counts of the
+	// Handler Stats as attributes, e.g. written=1200 dropped_timeout=3, is written, so silent
+	// loss of records is visible in the log itself. The stats record is written before the
+	// first record logged after the interval has passed, measured with Clock from the first
+	// record. If zero, no stats records are written; Stats is available regardless.
+	StatsInterval time.Duration
+
+	// CorrelationKey is the key of the attribute holding a correlation ID, such as a request or
+	// trace ID, e.g. "request_id". Its value is written in square brackets after the tags
+	// instead of among the attributes, so it's aligned like the tags, e.g.
+	// "INFO\t[http]\t[4bf92f35]\tRequest". The key includes the prefix of the groups set with
+	// WithGroup; attributes nested in group values don't match. If the Handler and the record
+	// both have the attribute, the last one is written. If empty, no attribute is moved.
+	CorrelationKey string
+
+	// ColumnWidths are the widths of the header columns for a fixed-width layout, which aligns
+	// the columns across lines without tools like column -t. It's meant for local reading:
+	// the columns are padded with trailing spaces and the longer ones are truncated, so
+	// the output is no longer exact. It's ignored in the FullyKeyed mode.
+	ColumnWidths ColumnWidths
+}
+
+// DefaultUnitSuffixes are the key suffixes and units used by InferUnits by default.
+// They are copied when a Handler is created, so changes affect only the handlers created later.
+var DefaultUnitSuffixes = map[string]string{
+	"_ns":      "ns",
+	"_us":      "µs",
+	"_ms":      "ms",
+	"_seconds": "s",
+	"_bytes":   "B",
+	"_percent": "%",
+	"_pct":     "%",
+}
+
+// ErrInvalidKey is returned by Handle in the StrictKeys mode for records with malformed attribute keys.
+var ErrInvalidKey = errors.New("slogtfmt: invalid attribute key")
+
+// TagMode specifies how the tags are rendered.
+type TagMode int
+
+const (
+	// TagBracket renders the tags in square brackets before the message, e.g. [db].
+	// Tags that need quoting, such as tags with spaces, are quoted, e.g. ["my tag"].
+	TagBracket TagMode = iota
+	// TagAttr renders the tags as attributes preceding all other attributes except
+	// the constant attributes, e.g. tag="db", with the key set by TagKey.
+	TagAttr
+	// TagBracketAndAttr renders the tags both in square brackets and as attributes.
+	TagBracketAndAttr
+)
+
+// ErrShadowedKey is passed to OnError when a record attribute replaces an attribute added with WithAttrs.
+// See [Options.WarnShadowedKeys].
+var ErrShadowedKey = errors.New("slogtfmt: record attribute shadows a handler attribute")
+
+// ErrAttrPanic is passed to OnError when formatting an attribute value panics.
+var ErrAttrPanic = errors.New("slogtfmt: attribute value panicked")
+
+// ErrWriteTimeout is returned by Handle when the record is not written within the WriteTimeout.
+var ErrWriteTimeout = errors.New("slogtfmt: write timed out")
+
+// FloatSpecials specifies how NaN and infinite float values are rendered.
+type FloatSpecials int
+
+const (
+	// FloatSpecialsLiteral renders the values as NaN, +Inf and -Inf.
+	FloatSpecialsLiteral FloatSpecials = iota
+	// FloatSpecialsQuoted renders the values as quoted strings, e.g. "NaN" or "+Inf".
+	FloatSpecialsQuoted
+	// FloatSpecialsNull renders the values as null, for consumers that reject non-finite numbers.
+	FloatSpecialsNull
+)
+
+// SortMode specifies how the attributes are sorted.
+type SortMode int
+
+const (
+	// SortNone keeps the attributes in their original order.
+	SortNone SortMode = iota
+	// SortByKey sorts the attributes by their full keys, including the group prefix.
+	SortByKey
+	// SortGrouped sorts the attributes by key within each group and keeps the attributes
+	// of a group contiguous: the attributes outside any group come first, followed by
+	// the groups sorted by name, e.g. a=1 b=2 req.id=3 req.path=4 resp.status=5.
+	SortGrouped
+)
+
+// FramingMode specifies how records are delimited in the output.
+type FramingMode int
+
+const (
+	// FramingNewline terminates each record with a newline.
+	FramingNewline FramingMode = iota
+	// FramingLengthPrefix prefixes each record with its length in bytes as a 4-byte big-endian
+	// unsigned integer, without a newline terminator. It allows binary transports to read
+	// records without scanning for newlines.
+	FramingLengthPrefix
+	// FramingLengthPrefixNewline prefixes each record with its length like FramingLengthPrefix
+	// and terminates it with a newline. The length includes the newline.
+	FramingLengthPrefixNewline
+)
+
+// MaxSourceFrames is the maximum number of stack frames included in the source.
+// See [Options.SourceFrames].
+const MaxSourceFrames = 32
+
+// LevelFormat specifies how the record level is rendered.
+type LevelFormat int
+
+const (
+	// LevelText renders the level name, e.g. ERROR or INFO+2.
+	LevelText LevelFormat = iota
+	// LevelNumeric renders the numeric level value, e.g. 8 or 2.
+	LevelNumeric
+	// LevelNumericText renders both the numeric value and the name, e.g. 8:ERROR or 2:INFO+2.
+	LevelNumericText
+	// LevelSyslog renders the syslog severity of the level, e.g. 3 for ERROR, as expected
+	// by syslog and Graylog (GELF) ingestion. See [SyslogSeverity].
+	LevelSyslog
+)
+
+// SyslogSeverity returns the syslog severity of the level, which is also used by GELF:
+// 3 (error) for ERROR and above, 4 (warning) for WARN and above, 5 (notice) for levels
+// between INFO and WARN, 6 (informational) for INFO and 7 (debug) for levels below INFO.
+func SyslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level > slog.LevelInfo:
+		return 5
+	case level == slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// TypeFormatter converts an arbitrary attribute value into a value suitable for logging.
+// It returns false if it doesn't handle the value.
+type TypeFormatter func(v any) (slog.Value, bool)
+
+// Vocabulary defines the literal words used for bool and nil attribute values,
+// for example to produce tokens expected by CSV or spreadsheet tools.
+type Vocabulary struct {
+	// TrueText is used for true values. If empty, "true" is used.
+	TrueText string
+	// FalseText is used for false values. If empty, "false" is used.
+	FalseText string
+	// NilText is used for nil values. If empty, "<nil>" is used.
+	NilText string
+}
+
+// ColumnWidths are the widths of the header columns in runes. Shorter columns are padded with
+// spaces and longer ones are truncated. The columns with a positive width are written even if
+// the record has no value for them, such as a record without tags, so the next columns stay
+// aligned. Zero widths leave the columns as they are.
+type ColumnWidths struct {
+	// Time is the width of the timestamp column.
+	Time int
+	// Level is the width of the level column.
+	Level int
+	// Tag is the width of the tag column, including the brackets of all tags.
+	// It's ignored if the tags are written as attributes with TagAttr.
+	Tag int
+	// Source is the width of the source column, which is truncated at the start,
+	// so the file name and the line number stay visible. It's ignored unless AddSource is set.
+	Source int
 }
 
+// PartitionWriter is implemented by writers that route log records by a partition token.
+// See [Options.PartitionToken].
+type PartitionWriter interface {
+	WritePartition(token string, p []byte) (n int, err error)
+}
+
+// DatePartition is a partition token function for [Options.PartitionToken]
+// that returns the date of t in the format "2006-01-02".
+func DatePartition(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
+
+// DurationFormat specifies how time.Duration attribute values are rendered.
+type DurationFormat int
+
+const (
+	// DurationString renders durations using time.Duration.String(), e.g. 1m30s.
+	DurationString DurationFormat = iota
+	// DurationSeconds renders durations as floating-point seconds, e.g. 1.5.
+	// The value is formatted the same way as float attributes.
+	DurationSeconds
+	// DurationISO8601 renders durations in the ISO 8601 duration format with hours, minutes
+	// and fractional seconds, e.g. PT1H30M or PT0.25S. Zero is rendered as PT0S,
+	// and negative durations have a leading minus sign, e.g. -PT1M1S.
+	DurationISO8601
+)
+
 // Handler is a custom implementation of [slog.Handler] that provides advanced formatting capabilities
 // for log records. It offers the following features:
 //   - Customizable time value formatting for both log timestamps and time attributes
 //   - Support for log record tagging using square brackets before the message
 //   - Optional inclusion of source code information (file and line number)
 type Handler struct {
-	opts Options
-	goas []groupOrAttrs
-	mu   *sync.Mutex
-	out  io.Writer
+	opts       Options
+	goas       []groupOrAttrs
+	mu         *sync.Mutex
+	out        io.Writer
+	state      *handlerState
+	constAttrs []byte    // ConstantAttrs formatted once at construction
+	start      time.Time // creation time for AddElapsed
+	levels     []levelVariant
+	keyErrs    []error // malformed keys of the groups and attributes found with StrictKeys
+}
+
+// levelVariant is the Handler with the LevelOptions for the records with the given level or higher.
+type levelVariant struct {
+	level   slog.Level
+	handler *Handler
+}
+
+// handlerState is the mutable state shared by a Handler and all handlers derived from it.
+// It is protected by the Handler mutex.
+type handlerState struct {
+	fold    foldState
+	tabular tabularState
+
+	// pendingWrite is closed when the write that exceeded the WriteTimeout returns.
+	pendingWrite chan struct{}
+
+	// lastTags are the tags of the last written record, for SeparateTags.
+	lastTags    string
+	hasLastTags bool
+
+	// lastTime is the time of the last written record, for DeltaTimestamps.
+	lastTime time.Time
+
+	// header is the HeaderLine that has not been written yet.
+	header []byte
+
+	// shadowedKeys are the keys reported by WarnShadowedKeys. Unlike the other fields,
+	// it's safe for concurrent use, because it's used while formatting.
+	shadowedKeys sync.Map
+
+	// endWritten reports whether the EndMarker has been written.
+	endWritten bool
+
+	// stats counts the handled records. Like shadowedKeys, it's safe for concurrent use.
+	stats statsCounters
+
+	// lastStats is the time of the last stats record, for StatsInterval.
+	lastStats time.Time
+}
+
+// recordBody is the position of the level, tag, source, message and attributes of a formatted record,
+// excluding the timestamp and the trailing fields such as the checksum.
+type recordBody struct {
+	start, end int
 }
 
 type groupOrAttrs struct {
@@ -56,15 +707,66 @@ type groupOrAttrs struct {
 // The tag key value will be put in square brackets before the log message.
 const tagKeyName = "__tag__"
 
+// timeFormatKeyName is the key used to override the timestamp format of a log record.
+const timeFormatKeyName = "__time_format__"
+
 const (
 	RFC3339Milli = "2006-01-02T15:04:05.000Z07:00"
 	RFC3339Micro = "2006-01-02T15:04:05.000000Z07:00"
+	// ISO8601Basic is the ISO 8601 basic format, e.g. 20240601T103000Z or 20240601T123000+0200.
+	ISO8601Basic = "20060102T150405Z0700"
+	// DateOnly is the date without the time, the same as time.DateOnly.
+	DateOnly = time.DateOnly
+	// TimeOnly is the time of day without the date and the time zone, the same as time.TimeOnly.
+	TimeOnly = time.TimeOnly
 )
 
+// zoneElements are the time zone elements of time.Format layouts, longest first.
+var zoneElements = []string{
+	"Z07:00:00", "-07:00:00", "Z070000", "-070000",
+	"Z07:00", "-07:00", "Z0700", "-0700",
+	"Z07", "-07", "MST",
+}
+
+// stripZone returns the layout without the time zone elements.
+// A space preceding a removed element is removed as well.
+func stripZone(layout string) string {
+	for _, zone := range zoneElements {
+		layout = strings.ReplaceAll(layout, " "+zone, "")
+		layout = strings.ReplaceAll(layout, zone, "")
+	}
+	return layout
+}
+
 // Tag returns an slog.Attr that can be used to set the tag for a log record.
-// The tag value will be put in square brackets before the log message.
+// The tag value will be put in square brackets before the log message. Tags with spaces,
+// brackets or control characters are quoted inside the brackets, e.g. ["my tag"].
 func Tag(name string) slog.Attr {
-	return slog.Attr{Key: tagKeyName, Value: slog.StringValue(name)}
+	return slog.Attr{Key: tagKeyName, Value: slog.AnyValue(tagValue(name))}
+}
+
+// tagValue is the value type of the tag attributes. Only attributes created by [Tag] are tags,
+// so attributes that happen to use the tag key are written as regular attributes.
+type tagValue string
+
+// isTag reports whether the attribute was created by Tag.
+func isTag(a slog.Attr) bool {
+	if a.Key != tagKeyName || a.Value.Kind() != slog.KindAny {
+		return false
+	}
+	_, ok := a.Value.Any().(tagValue)
+	return ok
+}
+
+// TimeFormatOverride returns an slog.Attr that overrides the timestamp format for a single log record,
+// for example to log a specific event with a higher precision:
+//
+//	logger.Info("Request received", slogtfmt.TimeFormatOverride(slogtfmt.RFC3339Micro))
+//
+// It can also be set with With() to override the format for all records of a logger.
+// The attribute itself is not included in the output.
+func TimeFormatOverride(layout string) slog.Attr {
+	return slog.Attr{Key: timeFormatKeyName, Value: slog.StringValue(layout)}
 }
 
 type Option = func(*Options)
@@ -110,6 +812,14 @@ func WithTimeAttributeFormat(timeAttributeFormat string) Option {
 	}
 }
 
+// WithTimeAttributeOmitZone returns an Option that sets whether to remove the time zone
+// from the time attribute format. See [Options.TimeAttributeOmitZone].
+func WithTimeAttributeOmitZone(omitZone bool) Option {
+	return func(opts *Options) {
+		opts.TimeAttributeOmitZone = omitZone
+	}
+}
+
 // WithTimeAttributeInUTC returns an Option that sets whether to use UTC time for the time attribute
 // in the log record. If timeAttributeInUTC is true, the time attribute will be in UTC time,
 // otherwise it will be in the local time zone.
@@ -119,147 +829,1443 @@ func WithTimeAttributeInUTC(timeAttributeInUTC bool) Option {
 	}
 }
 
-func defaultOptions() *Options {
-	return &Options{
-		Level:               slog.LevelInfo,
-		AddSource:           false,
-		TimeFormat:          RFC3339Milli,
-		TimeInUTC:           false,
-		TimeAttributeFormat: RFC3339Milli,
-		TimeAttributeInUTC:  false,
+// WithDurationFormat returns an Option that sets how time.Duration attribute values are rendered.
+func WithDurationFormat(durationFormat DurationFormat) Option {
+	return func(opts *Options) {
+		opts.DurationFormat = durationFormat
 	}
 }
 
-// NewHandlerWithOptions creates a new Handler with the provided io.Writer and a set of configurable options.
-// The options allow customizing the log level, whether to include source location, the time format, and whether to use UTC time.
-// If no options are provided, it will use the default options with the time format set to RFC3339Milli.
-func NewHandlerWithOptions(out io.Writer, opts ...Option) *Handler {
-	o := defaultOptions()
-	for _, opt := range opts {
-		opt(o)
+// WithConstantAttrs returns an Option that sets the attributes added to every log record.
+// The attributes are formatted once when the Handler is created.
+func WithConstantAttrs(attrs ...slog.Attr) Option {
+	return func(opts *Options) {
+		opts.ConstantAttrs = attrs
 	}
-	return NewHandler(out, o)
 }
 
-// NewHandler creates a new Handler with the provided io.Writer and Options.
-// If no Options are provided, it will use the default Options.
-// The default Options include:
-//   - Level set to slog.LevelInfo
-//   - Time formats are set to RFC3339Milli
-//   - Time values are in local time zone
-//
-// If Level is not set in opts, it will default to slog.LevelInfo.
-func NewHandler(out io.Writer, opts *Options) *Handler {
-	h := &Handler{
-		mu:  &sync.Mutex{},
-		out: out,
-	}
-	if opts == nil {
-		opts = defaultOptions()
+// WithPartitionToken returns an Option that sets the function deriving a partition token
+// from the record time. See [Options.PartitionToken].
+func WithPartitionToken(partitionToken func(t time.Time) string) Option {
+	return func(opts *Options) {
+		opts.PartitionToken = partitionToken
 	}
+}
 
-	h.opts = *opts
+// WithHeaderSeparator returns an Option that sets the separator between the segments
+// preceding the message. See [Options.HeaderSeparator].
+func WithHeaderSeparator(separator string) Option {
+	return func(opts *Options) {
+		opts.HeaderSeparator = separator
+	}
+}
 
-	if h.opts.Level == nil {
-		h.opts.Level = slog.LevelInfo
+// WithVocabulary returns an Option that sets the literal words used for bool and nil attribute values.
+func WithVocabulary(vocabulary Vocabulary) Option {
+	return func(opts *Options) {
+		opts.Vocabulary = vocabulary
 	}
+}
 
-	if h.opts.TimeAttributeFormat == "" {
-		h.opts.TimeAttributeFormat = RFC3339Milli
+// WithTypeFormatters returns an Option that adds formatters for attribute values of kind slog.KindAny.
+// See [Options.TypeFormatters].
+func WithTypeFormatters(formatters ...TypeFormatter) Option {
+	return func(opts *Options) {
+		// Clip the capacity to avoid modifying the formatters of other handlers.
+		opts.TypeFormatters = append(opts.TypeFormatters[:len(opts.TypeFormatters):len(opts.TypeFormatters)], formatters...)
 	}
+}
 
-	return h
+// WithAddChecksum returns an Option that sets whether to append a checksum to each record.
+// See [Options.AddChecksum].
+func WithAddChecksum(addChecksum bool) Option {
+	return func(opts *Options) {
+		opts.AddChecksum = addChecksum
+	}
 }
 
-// Enabled returns whether the given log level is enabled for this Handler.
-// The Handler will only log records with a level greater than or equal to the configured level.
-func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
-	return level >= h.opts.Level.Level()
+// WithLevelFormat returns an Option that sets how the record level is rendered.
+func WithLevelFormat(levelFormat LevelFormat) Option {
+	return func(opts *Options) {
+		opts.LevelFormat = levelFormat
+	}
 }
 
-// Handle processes a log record and writes it to the configured io.Writer.
-// It appends the time, level, tag (if set), source location (if configured),
-// message, and attributes to the output. The output is formatted according to the
-// configured Options.
-func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
-	bufp := allocBuf()
-	buf := *bufp
-	defer func() {
-		*bufp = buf
-		freeBuf(bufp)
-	}()
+// WithKeyValueSeparator returns an Option that sets the separator between attribute keys and values.
+func WithKeyValueSeparator(separator string) Option {
+	return func(opts *Options) {
+		opts.KeyValueSeparator = separator
+	}
+}
 
-	// Append the time.
-	if h.opts.TimeFormat != "" && !r.Time.IsZero() {
-		if h.opts.TimeInUTC {
-			buf = append(buf, r.Time.UTC().Format(h.opts.TimeFormat)...)
-		} else {
-			buf = append(buf, r.Time.Format(h.opts.TimeFormat)...)
-		}
-		buf = append(buf, "\t"...)
+// WithSourceMinLevel returns an Option that sets the minimum level of records
+// that include the source when AddSource is set.
+func WithSourceMinLevel(level slog.Leveler) Option {
+	return func(opts *Options) {
+		opts.SourceMinLevel = level
 	}
+}
 
-	// Append the level.
-	buf = append(buf, r.Level.String()...)
+// WithSourceFrames returns an Option that sets the number of stack frames included in the source.
+// See [Options.SourceFrames].
+func WithSourceFrames(frames int) Option {
+	return func(opts *Options) {
+		opts.SourceFrames = frames
+	}
+}
 
-	goas := h.goas
-	// Append the tag. Tag must be set by With().
-	for _, goa := range goas {
-		for _, a := range goa.attrs {
-			if a.Key == tagKeyName {
-				buf = append(buf, "\t["...)
-				buf = append(buf, a.Value.String()...)
-				buf = append(buf, "]"...)
-				break
-			}
-		}
+// WithExpandErrorChain returns an Option that sets whether to render the innermost cause
+// of wrapped error attribute values. See [Options.ExpandErrorChain].
+func WithExpandErrorChain(expandErrorChain bool) Option {
+	return func(opts *Options) {
+		opts.ExpandErrorChain = expandErrorChain
 	}
+}
 
-	// Append the source.
-	if h.opts.AddSource {
-		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+// WithFoldRepeats returns an Option that sets whether to fold consecutive identical records.
+// See [Options.FoldRepeats].
+func WithFoldRepeats(foldRepeats bool) Option {
+	return func(opts *Options) {
+		opts.FoldRepeats = foldRepeats
+	}
+}
 
-		buf = append(buf, "\t"...)
-		buf = append(buf, frame.File...)
-		buf = append(buf, ":"...)
-		buf = strconv.AppendInt(buf, int64(frame.Line), 10)
+// WithTabularAttrs returns an Option that sets whether to align attribute values in columns.
+// See [Options.TabularAttrs].
+func WithTabularAttrs(tabularAttrs bool) Option {
+	return func(opts *Options) {
+		opts.TabularAttrs = tabularAttrs
 	}
+}
 
-	// Append the message.
-	buf = append(buf, "\t"...)
-	buf = append(buf, r.Message...)
+// WithLevelLabels returns an Option that sets custom level names. See [Options.LevelLabels].
+func WithLevelLabels(labels map[slog.Level]string) Option {
+	return func(opts *Options) {
+		opts.LevelLabels = labels
+	}
+}
 
-	// Append the groups.
-	if r.NumAttrs() == 0 {
-		// If the record has no Attrs, remove groups at the end of the list
-		for len(goas) > 0 && goas[len(goas)-1].group != "" {
-			goas = goas[:len(goas)-1]
-		}
+// WithFramingMode returns an Option that sets how records are delimited in the output.
+func WithFramingMode(framingMode FramingMode) Option {
+	return func(opts *Options) {
+		opts.FramingMode = framingMode
 	}
-	groupPrefix := ""
-	for _, goa := range goas {
-		if goa.group != "" {
-			groupPrefix += goa.group + "."
-		}
-		for _, a := range goa.attrs {
-			if a.Key != tagKeyName {
-				buf = h.appendAttr(buf, a, groupPrefix)
-			}
-		}
+}
+
+// WithPriorityKeys returns an Option that sets the attribute keys written before all other attributes.
+// See [Options.PriorityKeys].
+func WithPriorityKeys(keys ...string) Option {
+	return func(opts *Options) {
+		opts.PriorityKeys = keys
 	}
+}
 
-	// Append the attributes.
-	r.Attrs(func(attr slog.Attr) bool {
-		buf = h.appendAttr(buf, attr, groupPrefix)
-		return true
+// WithAddRecordID returns an Option that sets whether to append the record_id field.
+// See [Options.AddRecordID].
+func WithAddRecordID(addRecordID bool) Option {
+	return func(opts *Options) {
+		opts.AddRecordID = addRecordID
+	}
+}
+
+// WithSourceRoot returns an Option that sets the root directory of the source file paths.
+// See [Options.SourceRoot].
+func WithSourceRoot(root string) Option {
+	return func(opts *Options) {
+		opts.SourceRoot = root
+	}
+}
+
+// WithFloatSpecials returns an Option that sets how NaN and infinite float values are rendered.
+// See [Options.FloatSpecials].
+func WithFloatSpecials(floatSpecials FloatSpecials) Option {
+	return func(opts *Options) {
+		opts.FloatSpecials = floatSpecials
+	}
+}
+
+// WithLevelFunc returns an Option that sets the function returning the minimum level at a given time.
+// See [Options.LevelFunc].
+func WithLevelFunc(levelFunc func(t time.Time) slog.Level) Option {
+	return func(opts *Options) {
+		opts.LevelFunc = levelFunc
+	}
+}
+
+// WithTrimMessage returns an Option that sets whether to trim the white space around the message.
+// See [Options.TrimMessage].
+func WithTrimMessage(trimMessage bool) Option {
+	return func(opts *Options) {
+		opts.TrimMessage = trimMessage
+	}
+}
+
+// WithWriteTimeout returns an Option that sets the maximum time to wait for the output writer.
+// See [Options.WriteTimeout].
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(opts *Options) {
+		opts.WriteTimeout = timeout
+	}
+}
+
+// WithSafeRunes returns an Option that sets the predicate of runes allowed in unquoted string values.
+// See [Options.SafeRunes].
+func WithSafeRunes(safeRunes func(r rune) bool) Option {
+	return func(opts *Options) {
+		opts.SafeRunes = safeRunes
+	}
+}
+
+// WithTagMode returns an Option that sets how the tags are rendered.
+// See [Options.TagMode].
+func WithTagMode(tagMode TagMode) Option {
+	return func(opts *Options) {
+		opts.TagMode = tagMode
+	}
+}
+
+// WithTagKey returns an Option that sets the attribute key of the tags.
+// See [Options.TagKey].
+func WithTagKey(key string) Option {
+	return func(opts *Options) {
+		opts.TagKey = key
+	}
+}
+
+// WithStrictKeys returns an Option that sets whether to reject records with malformed attribute keys.
+// See [Options.StrictKeys].
+func WithStrictKeys(strictKeys bool) Option {
+	return func(opts *Options) {
+		opts.StrictKeys = strictKeys
+	}
+}
+
+// WithAttrsBrackets returns an Option that sets whether to wrap the attributes in delimiters.
+// See [Options.AttrsBrackets].
+func WithAttrsBrackets(attrsBrackets bool) Option {
+	return func(opts *Options) {
+		opts.AttrsBrackets = attrsBrackets
+	}
+}
+
+// WithAttrsDelimiters returns an Option that sets the opening and closing delimiters of the attributes.
+// See [Options.AttrsDelimiters].
+func WithAttrsDelimiters(open, close string) Option {
+	return func(opts *Options) {
+		opts.AttrsDelimiters = [2]string{open, close}
+	}
+}
+
+// WithComponent returns an Option that sets the name of the application component.
+// See [Options.Component].
+func WithComponent(component string) Option {
+	return func(opts *Options) {
+		opts.Component = component
+	}
+}
+
+// WithComponentWidth returns an Option that sets the minimum width of the component column.
+// See [Options.ComponentWidth].
+func WithComponentWidth(width int) Option {
+	return func(opts *Options) {
+		opts.ComponentWidth = width
+	}
+}
+
+// WithAddElapsed returns an Option that sets whether to write the time elapsed since the Handler was created.
+// See [Options.AddElapsed].
+func WithAddElapsed(addElapsed bool) Option {
+	return func(opts *Options) {
+		opts.AddElapsed = addElapsed
+	}
+}
+
+// WithKeyAliases returns an Option that sets the renamed attribute keys.
+// See [Options.KeyAliases].
+func WithKeyAliases(aliases map[string]string) Option {
+	return func(opts *Options) {
+		opts.KeyAliases = aliases
+	}
+}
+
+// WithInferUnits returns an Option that sets whether to append units inferred from the key suffixes.
+// See [Options.InferUnits].
+func WithInferUnits(inferUnits bool) Option {
+	return func(opts *Options) {
+		opts.InferUnits = inferUnits
+	}
+}
+
+// WithUnitSuffixes returns an Option that sets the key suffixes and units used by InferUnits.
+// See [Options.UnitSuffixes].
+func WithUnitSuffixes(suffixes map[string]string) Option {
+	return func(opts *Options) {
+		opts.UnitSuffixes = suffixes
+	}
+}
+
+// WithEmitEmpty returns an Option that sets whether to write empty groups.
+// See [Options.EmitEmpty].
+func WithEmitEmpty(emitEmpty bool) Option {
+	return func(opts *Options) {
+		opts.EmitEmpty = emitEmpty
+	}
+}
+
+// WithOnRecord returns an Option that sets the callback called after each written record.
+// See [Options.OnRecord].
+func WithOnRecord(onRecord func(level slog.Level, n int)) Option {
+	return func(opts *Options) {
+		opts.OnRecord = onRecord
+	}
+}
+
+// WithRelativeTimeAttrs returns an Option that sets whether to render time attributes relative to the current time.
+// See [Options.RelativeTimeAttrs].
+func WithRelativeTimeAttrs(relativeTimeAttrs bool) Option {
+	return func(opts *Options) {
+		opts.RelativeTimeAttrs = relativeTimeAttrs
+	}
+}
+
+// WithClock returns an Option that sets the function returning the current time.
+// See [Options.Clock].
+func WithClock(clock func() time.Time) Option {
+	return func(opts *Options) {
+		opts.Clock = clock
+	}
+}
+
+// WithKeyNamespace returns an Option that sets the namespace prepended to all attribute keys.
+// See [Options.KeyNamespace].
+func WithKeyNamespace(namespace string) Option {
+	return func(opts *Options) {
+		opts.KeyNamespace = namespace
+	}
+}
+
+// WithFullyKeyed returns an Option that sets whether to write the header segments as key=value fields.
+// See [Options.FullyKeyed].
+func WithFullyKeyed(fullyKeyed bool) Option {
+	return func(opts *Options) {
+		opts.FullyKeyed = fullyKeyed
+	}
+}
+
+// WithLevelComparator returns an Option that sets the function comparing record levels with the threshold.
+// See [Options.LevelComparator].
+func WithLevelComparator(comparator func(level, threshold slog.Level) bool) Option {
+	return func(opts *Options) {
+		opts.LevelComparator = comparator
+	}
+}
+
+// WithSeparateTags returns an Option that sets whether to separate records with different tags.
+// See [Options.SeparateTags].
+func WithSeparateTags(separateTags bool) Option {
+	return func(opts *Options) {
+		opts.SeparateTags = separateTags
+	}
+}
+
+// WithTagSeparator returns an Option that sets the separator written between records with different tags.
+// See [Options.TagSeparator].
+func WithTagSeparator(separator string) Option {
+	return func(opts *Options) {
+		opts.TagSeparator = separator
+	}
+}
+
+// WithBuildInfo returns an Option that sets whether to add the build info to every record.
+// See [Options.BuildInfo].
+func WithBuildInfo(buildInfo bool) Option {
+	return func(opts *Options) {
+		opts.BuildInfo = buildInfo
+	}
+}
+
+// WithSortAttrs returns an Option that sets how the attributes are sorted.
+// See [Options.SortAttrs].
+func WithSortAttrs(mode SortMode) Option {
+	return func(opts *Options) {
+		opts.SortAttrs = mode
+	}
+}
+
+// WithFloatPrecision returns an Option that sets the number of digits after the decimal point
+// of float attribute values. See [Options.FloatPrecision].
+func WithFloatPrecision(precision int) Option {
+	return func(opts *Options) {
+		opts.FloatPrecision = precision
+	}
+}
+
+// WithGroupSeparator returns an Option that sets the separator of the group names in the attribute keys.
+// See [Options.GroupSeparator].
+func WithGroupSeparator(separator string) Option {
+	return func(opts *Options) {
+		opts.GroupSeparator = separator
+	}
+}
+
+// WithEscapeKeys returns an Option that sets whether to escape the group separator in the attribute keys.
+// See [Options.EscapeKeys].
+func WithEscapeKeys(escapeKeys bool) Option {
+	return func(opts *Options) {
+		opts.EscapeKeys = escapeKeys
+	}
+}
+
+// WithOnError returns an Option that sets the function called with the errors that don't fail
+// the logging call. See [Options.OnError].
+func WithOnError(onError func(error)) Option {
+	return func(opts *Options) {
+		opts.OnError = onError
+	}
+}
+
+// WithMaxGroupDepth returns an Option that sets the maximum nesting depth of the groups in attribute values.
+// See [Options.MaxGroupDepth].
+func WithMaxGroupDepth(depth int) Option {
+	return func(opts *Options) {
+		opts.MaxGroupDepth = depth
+	}
+}
+
+// WithDeltaTimestamps returns an Option that sets whether to write the time since the previous record.
+// See [Options.DeltaTimestamps].
+func WithDeltaTimestamps(deltaTimestamps bool) Option {
+	return func(opts *Options) {
+		opts.DeltaTimestamps = deltaTimestamps
+	}
+}
+
+// WithHeaderLine returns an Option that sets whether to write a comment line describing the columns.
+// See [Options.HeaderLine].
+func WithHeaderLine(headerLine bool) Option {
+	return func(opts *Options) {
+		opts.HeaderLine = headerLine
+	}
+}
+
+// WithLevelOptions returns an Option that sets the options applied to the records with the given level or higher.
+// See [Options.LevelOptions].
+func WithLevelOptions(level slog.Level, opts ...Option) Option {
+	return func(o *Options) {
+		levelOptions := make(map[slog.Level][]Option, len(o.LevelOptions)+1)
+		for l, lo := range o.LevelOptions {
+			levelOptions[l] = lo
+		}
+		levelOptions[level] = opts
+		o.LevelOptions = levelOptions
+	}
+}
+
+// WithPositionalKeys returns an Option that sets the attribute keys whose values are written without the keys.
+// See [Options.PositionalKeys].
+func WithPositionalKeys(keys ...string) Option {
+	return func(opts *Options) {
+		opts.PositionalKeys = keys
+	}
+}
+
+// WithJoinTags returns an Option that sets the separator used to join the tags into a single tag.
+// See [Options.JoinTags].
+func WithJoinTags(separator string) Option {
+	return func(opts *Options) {
+		opts.JoinTags = separator
+	}
+}
+
+// WithSourceAsURI returns an Option that sets whether to write the source file as a file URI.
+// See [Options.SourceAsURI].
+func WithSourceAsURI(sourceAsURI bool) Option {
+	return func(opts *Options) {
+		opts.SourceAsURI = sourceAsURI
+	}
+}
+
+// WithDedupKeys returns an Option that sets whether to write only the last of the attributes with the same key.
+// See [Options.DedupKeys].
+func WithDedupKeys(dedupKeys bool) Option {
+	return func(opts *Options) {
+		opts.DedupKeys = dedupKeys
+	}
+}
+
+// WithWarnShadowedKeys returns an Option that sets whether to report the record attributes
+// replacing the attributes added with WithAttrs. See [Options.WarnShadowedKeys].
+func WithWarnShadowedKeys(warn bool) Option {
+	return func(opts *Options) {
+		opts.WarnShadowedKeys = warn
+	}
+}
+
+// WithEndMarker returns an Option that sets the end-of-stream marker written by Close.
+// See [Options.EndMarker].
+func WithEndMarker(marker string) Option {
+	return func(opts *Options) {
+		opts.EndMarker = marker
+	}
+}
+
+// WithAutoTagFromPackage returns an Option that sets whether the records are tagged with the package of the caller.
+// See [Options.AutoTagFromPackage].
+func WithAutoTagFromPackage(autoTag bool) Option {
+	return func(opts *Options) {
+		opts.AutoTagFromPackage = autoTag
+	}
+}
+
+// WithFallbackHandler returns an Option that sets the handler for the records that fail to be logged.
+// See [Options.FallbackHandler].
+func WithFallbackHandler(fallback slog.Handler) Option {
+	return func(opts *Options) {
+		opts.FallbackHandler = fallback
+	}
+}
+
+// WithMultilineAttrs returns an Option that sets whether each attribute is written on its own line.
+// See [Options.MultilineAttrs].
+func WithMultilineAttrs(multiline bool) Option {
+	return func(opts *Options) {
+		opts.MultilineAttrs = multiline
+	}
+}
+
+// WithOpaqueValues returns an Option that sets whether func, channel and unsafe.Pointer values are written as placeholders.
+// See [Options.OpaqueValues].
+func WithOpaqueValues(opaque bool) Option {
+	return func(opts *Options) {
+		opts.OpaqueValues = opaque
+	}
+}
+
+// WithStatsInterval returns an Option that sets the interval at which the stats record is written.
+// See [Options.StatsInterval].
+func WithStatsInterval(interval time.Duration) Option {
+	return func(opts *Options) {
+		opts.StatsInterval = interval
+	}
+}
+
+// WithCorrelationKey returns an Option that sets the key of the attribute written in the correlation ID column.
+// See [Options.CorrelationKey].
+func WithCorrelationKey(key string) Option {
+	return func(opts *Options) {
+		opts.CorrelationKey = key
+	}
+}
+
+// WithColumnWidths returns an Option that sets the widths of the header columns.
+// See [Options.ColumnWidths].
+func WithColumnWidths(widths ColumnWidths) Option {
+	return func(opts *Options) {
+		opts.ColumnWidths = widths
+	}
+}
+
+func defaultOptions() *Options {
+	return &Options{
+		Level:               slog.LevelInfo,
+		AddSource:           false,
+		TimeFormat:          RFC3339Milli,
+		TimeInUTC:           false,
+		TimeAttributeFormat: RFC3339Milli,
+		TimeAttributeInUTC:  false,
+		DurationFormat:      DurationString,
+		HeaderSeparator:     "\t",
+		LevelFormat:         LevelText,
+		KeyValueSeparator:   "=",
+		FramingMode:         FramingNewline,
+	}
+}
+
+// NewHandlerWithOptions creates a new Handler with the provided io.Writer and a set of configurable options.
+// The options allow customizing the log level, whether to include source location, the time format, and whether to use UTC time.
+// If no options are provided, it will use the default options with the time format set to RFC3339Milli.
+func NewHandlerWithOptions(out io.Writer, opts ...Option) *Handler {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	return NewHandler(out, o)
+}
+
+// NewHandler creates a new Handler with the provided io.Writer and Options.
+// If no Options are provided, it will use the default Options.
+// The default Options include:
+//   - Level set to slog.LevelInfo
+//   - Time formats are set to RFC3339Milli
+//   - Time values are in local time zone
+//
+// If Level is not set in opts, it will default to slog.LevelInfo.
+func NewHandler(out io.Writer, opts *Options) *Handler {
+	h := &Handler{
+		mu:    &sync.Mutex{},
+		out:   out,
+		state: &handlerState{},
+		start: time.Now(),
+	}
+	if opts == nil {
+		opts = defaultOptions()
+	}
+
+	h.opts = *opts
+	h.init()
+
+	if h.opts.HeaderLine && !h.opts.FullyKeyed {
+		h.state.header = h.headerLine()
+		if err := h.writeHeader(); err != nil && h.opts.OnError != nil {
+			h.opts.OnError(err)
+		}
+	}
+	return h
+}
+
+// WithOptions returns a new Handler derived from h with the given options applied on top of its options,
+// for example to enable the source or to lower the level for a subsystem.
+// The new Handler keeps the groups and attributes of h and shares its writer, mutex and state,
+// such as the folded repeated records, so the output of both handlers is not interleaved.
+func (h *Handler) WithOptions(opts ...Option) *Handler {
+	h2 := *h
+	for _, opt := range opts {
+		opt(&h2.opts)
+	}
+	h2.init()
+	return &h2
+}
+
+// WithTemporaryLevel returns a new Handler derived from h with the given level, for example
+// to log at the debug level in a scope, and a function that restores the level of h:
+//
+//	debugHandler, restore := handler.WithTemporaryLevel(slog.LevelDebug)
+//	defer restore()
+//
+// After restore is called, the derived Handler follows the level of h. Calling restore more than
+// once is safe. The derived Handler shares the writer, mutex and state of h, like WithOptions.
+// Note that LevelFunc and the level set by [ContextWithLevel] take precedence over the level.
+func (h *Handler) WithTemporaryLevel(level slog.Level) (*Handler, func()) {
+	l := &temporaryLevel{level: level, base: h.opts.Level}
+	return h.WithOptions(WithLevel(l)), func() {
+		l.restored.Store(true)
+	}
+}
+
+// temporaryLevel is a Leveler that returns the temporary level until it's restored,
+// and the base level afterwards.
+type temporaryLevel struct {
+	level    slog.Level
+	base     slog.Leveler
+	restored atomic.Bool
+}
+
+// Level returns the temporary level or, once restored, the base level.
+func (l *temporaryLevel) Level() slog.Level {
+	if l.restored.Load() {
+		return l.base.Level()
+	}
+	return l.level
+}
+
+// init sets the defaults of unset options and prepares the data derived from the options.
+func (h *Handler) init() {
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+
+	if h.opts.TimeAttributeFormat == "" {
+		h.opts.TimeAttributeFormat = RFC3339Milli
+	}
+	if h.opts.TimeAttributeOmitZone {
+		h.opts.TimeAttributeFormat = stripZone(h.opts.TimeAttributeFormat)
+	}
+
+	if h.opts.HeaderSeparator == "" {
+		h.opts.HeaderSeparator = "\t"
+	}
+
+	if h.opts.KeyValueSeparator == "" {
+		h.opts.KeyValueSeparator = "="
+	}
+
+	if h.opts.GroupSeparator == "" {
+		h.opts.GroupSeparator = "."
+	}
+
+	if h.opts.Clock == nil {
+		h.opts.Clock = time.Now
+	}
+
+	if h.opts.UnitSuffixes == nil {
+		// Copy the defaults, so that changing them doesn't affect the existing handlers.
+		h.opts.UnitSuffixes = maps.Clone(DefaultUnitSuffixes)
+	}
+
+	if h.opts.TagSeparator == "" {
+		h.opts.TagSeparator = "\n"
+	}
+
+	if h.opts.TagKey == "" {
+		h.opts.TagKey = "tag"
+	}
+
+	// The attributes on separate lines can't be aligned in columns.
+	if h.opts.MultilineAttrs {
+		h.opts.TabularAttrs = false
+	}
+
+	if h.opts.AttrsDelimiters[0] == "" {
+		h.opts.AttrsDelimiters[0] = "{"
+	}
+	if h.opts.AttrsDelimiters[1] == "" {
+		h.opts.AttrsDelimiters[1] = "}"
+	}
+
+	if h.opts.Vocabulary.TrueText == "" {
+		h.opts.Vocabulary.TrueText = "true"
+	}
+	if h.opts.Vocabulary.FalseText == "" {
+		h.opts.Vocabulary.FalseText = "false"
+	}
+	if h.opts.Vocabulary.NilText == "" {
+		h.opts.Vocabulary.NilText = "<nil>"
+	}
+
+	// Constant attributes are the same on every record, so they don't need to be
+	// aligned and must not use the shared state, which requires the mutex.
+	plain := *h
+	plain.opts.TabularAttrs = false
+	h.constAttrs = nil
+	for _, a := range h.opts.ConstantAttrs {
+		h.constAttrs = plain.appendAttr(h.constAttrs, a, "", 0)
+	}
+	if h.opts.BuildInfo {
+		h.constAttrs = plain.appendAttr(h.constAttrs, buildInfoAttr(), "", 0)
+	}
+
+	h.levels = nil
+	for level, opts := range h.opts.LevelOptions {
+		v := *h
+		v.opts.LevelOptions = nil
+		for _, opt := range opts {
+			opt(&v.opts)
+		}
+		v.opts.Level, v.opts.LevelFunc, v.opts.LevelComparator = h.opts.Level, h.opts.LevelFunc, h.opts.LevelComparator
+		v.init()
+		h.levels = append(h.levels, levelVariant{level: level, handler: &v})
+	}
+	// The most severe levels are checked first.
+	slices.SortFunc(h.levels, func(a, b levelVariant) int {
+		switch {
+		case a.level == b.level:
+			return 0
+		case h.levelEnabled(a.level, b.level):
+			return -1
+		default:
+			return 1
+		}
 	})
+}
+
+// updateLevels replaces the level variants of h with copies that have the groups and attributes of h.
+func (h *Handler) updateLevels() {
+	if len(h.levels) == 0 {
+		return
+	}
+	levels := make([]levelVariant, len(h.levels))
+	for i, v := range h.levels {
+		v2 := *v.handler
+		v2.goas, v2.keyErrs = h.goas, h.keyErrs
+		levels[i] = levelVariant{level: v.level, handler: &v2}
+	}
+	h.levels = levels
+}
+
+// forLevel returns the Handler with the LevelOptions of the given level applied, or h itself
+// if there are none.
+func (h *Handler) forLevel(level slog.Level) *Handler {
+	for _, v := range h.levels {
+		if h.levelEnabled(level, v.level) {
+			return v.handler
+		}
+	}
+	return h
+}
+
+// buildInfoAttr returns the build group added by BuildInfo, read once per process.
+var buildInfoAttr = sync.OnceValue(func() slog.Attr {
+	return buildAttr(debug.ReadBuildInfo())
+})
+
+// buildAttr returns the build group with the main module version and the VCS revision,
+// or an empty Attr if neither is known.
+func buildAttr(info *debug.BuildInfo, ok bool) slog.Attr {
+	if !ok {
+		return slog.Attr{}
+	}
+	var attrs []slog.Attr
+	if v := info.Main.Version; v != "" {
+		attrs = append(attrs, slog.String("version", v))
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && setting.Value != "" {
+			attrs = append(attrs, slog.String("revision", setting.Value))
+		}
+	}
+	if len(attrs) == 0 {
+		return slog.Attr{}
+	}
+	return slog.Attr{Key: "build", Value: slog.GroupValue(attrs...)}
+}
+
+// Enabled returns whether the given log level is enabled for this Handler.
+// The Handler will only log records with a level greater than or equal to the configured level,
+// unless LevelComparator is set. If ctx carries a level override set by [ContextWithLevel], it is used instead of the configured level.
+// If LevelFunc is set, it's called with the current time to get the level.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if override, ok := levelFromContext(ctx); ok {
+		return h.levelEnabled(level, override.Level())
+	}
+	if h.opts.LevelFunc != nil {
+		return h.levelEnabled(level, h.opts.LevelFunc(time.Now()))
+	}
+	return h.levelEnabled(level, h.opts.Level.Level())
+}
+
+// levelEnabled reports whether records with the level pass the threshold,
+// using the LevelComparator if set.
+func (h *Handler) levelEnabled(level, threshold slog.Level) bool {
+	if h.opts.LevelComparator != nil {
+		return h.opts.LevelComparator(level, threshold)
+	}
+	return level >= threshold
+}
+
+// Handle processes a log record and writes it to the configured io.Writer.
+// It appends the time, level, tag (if set), source location (if configured),
+// message, and attributes to the output. The output is formatted according to the
+// configured Options. The record is not modified: the attributes added by the Handler,
+// such as the constant attributes, are formatted separately, so the same record can be
+// passed to several handlers.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	h = h.forLevel(r.Level)
+	err := h.handle(ctx, r)
+	if err != nil {
+		h.state.stats.countDropped(err)
+	}
+	if err != nil && h.opts.FallbackHandler != nil && !isFallback(ctx) {
+		err = errors.Join(err, h.fallback().Handle(contextWithFallback(ctx), r))
+	}
+	return err
+}
+
+// fallback returns the FallbackHandler with the attributes and groups of the Handler.
+func (h *Handler) fallback() slog.Handler {
+	fallback := h.opts.FallbackHandler
+	for _, goa := range h.goas {
+		if goa.group != "" {
+			fallback = fallback.WithGroup(goa.group)
+			continue
+		}
+		attrs := make([]slog.Attr, 0, len(goa.attrs))
+		for _, a := range goa.attrs {
+			if isTag(a) {
+				a = slog.String(h.opts.TagKey, a.Value.String())
+			}
+			attrs = append(attrs, a)
+		}
+		fallback = fallback.WithAttrs(attrs)
+	}
+	return fallback
+}
+
+// handle formats the record and writes it to the output.
+func (h *Handler) handle(ctx context.Context, r slog.Record) error {
+	// The scheduled level depends on the record time, which may differ from the time Enabled was called.
+	if h.opts.LevelFunc != nil && !r.Time.IsZero() {
+		if _, ok := levelFromContext(ctx); !ok && !h.levelEnabled(r.Level, h.opts.LevelFunc(r.Time)) {
+			h.state.stats.droppedLevel.Add(1)
+			return nil
+		}
+	}
+
+	if h.opts.StrictKeys {
+		r = h.resolveRecord(r)
+		if err := h.checkKeys(r); err != nil {
+			return err
+		}
+	}
+
+	bufp := allocBuf()
+	buf := *bufp
+	defer func() {
+		*bufp = buf
+		freeBuf(bufp)
+	}()
+
+	// Stateful formatting must be done under the mutex.
+	locked := h.formatLocked()
+	if locked {
+		h.mu.Lock()
+	}
+	buf, body := h.appendRecord(buf, ctx, r)
+	if !locked {
+		h.mu.Lock()
+	}
+	defer h.mu.Unlock()
+
+	if err := h.writeHeader(); err != nil {
+		return err
+	}
+	if h.opts.StatsInterval > 0 {
+		if err := h.writeStats(); err != nil {
+			return err
+		}
+	}
+	if h.opts.FoldRepeats {
+		if h.state.fold.repeated(buf[body.start:body.end], r) {
+			h.state.stats.folded.Add(1)
+			return nil
+		}
+		if err := h.writeFoldSummary(); err != nil {
+			return err
+		}
+	}
+	if h.opts.SeparateTags {
+		if err := h.writeTagSeparator(ctx, r); err != nil {
+			return err
+		}
+	}
+	if err := h.write(buf, r.Level, r.Time); err != nil {
+		return err
+	}
+	h.state.stats.written.Add(1)
+	return nil
+}
+
+// Close writes any pending output, such as the summary of folded repeated records, and the
+// EndMarker, and flushes the output writer if it has a Flush() error method, like extras.BatchWriter.
+// The Handler can still be used after Close, but the EndMarker is only written once, unless
+// writing it fails, in which case the next Close writes it again. Close applies
+// to the Handler and all handlers derived from it with WithAttrs and WithGroup.
+//
+// If a write that exceeded the WriteTimeout is still blocked, Close waits for it up to the
+// WriteTimeout. If it's still blocked then, Close returns ErrWriteTimeout without writing or
+// flushing anything, so it can be called again later.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.waitPendingWrite() {
+		return ErrWriteTimeout
+	}
+	err := h.writeFoldSummary()
+	if err == nil && h.opts.EndMarker != "" && !h.state.endWritten {
+		err = h.writeTimeout(h.endMarker(), h.opts.Clock())
+		// A timed out write may still complete, so it's not retried either.
+		h.state.endWritten = err == nil || errors.Is(err, ErrWriteTimeout)
+	}
+	if f, ok := h.out.(interface{ Flush() error }); ok {
+		err = errors.Join(err, f.Flush())
+	}
+	return err
+}
+
+// endMarker returns the EndMarker framed according to the FramingMode.
+func (h *Handler) endMarker() []byte {
+	if h.opts.FramingMode != FramingLengthPrefix && h.opts.FramingMode != FramingLengthPrefixNewline {
+		return []byte(h.opts.EndMarker)
+	}
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(h.opts.EndMarker)))
+	return append(buf, h.opts.EndMarker...)
+}
+
+// Columns returns the names of the header columns of the lines written by the Handler, in order:
+// time, elapsed, delta, level, component, tag, source and message, depending on the options.
+// The tag column is present if the tags are rendered in brackets, and only on the lines of records
+// with tags. The message column is followed by the PositionalKeys and the other attributes.
+func (h *Handler) Columns() []string {
+	var columns []string
+	if h.opts.TimeFormat != "" {
+		columns = append(columns, "time")
+	}
+	if h.opts.AddElapsed {
+		columns = append(columns, "elapsed")
+	}
+	if h.opts.DeltaTimestamps {
+		columns = append(columns, "delta")
+	}
+	columns = append(columns, "level")
+	if h.opts.Component != "" {
+		columns = append(columns, "component")
+	}
+	if h.opts.TagMode != TagAttr {
+		columns = append(columns, "tag")
+	}
+	if h.opts.CorrelationKey != "" {
+		columns = append(columns, h.opts.CorrelationKey)
+	}
+	if h.opts.AddSource {
+		columns = append(columns, "source")
+	}
+	columns = append(columns, "message")
+	return append(columns, h.opts.PositionalKeys...)
+}
+
+// headerLine returns the comment line describing the columns for HeaderLine.
+func (h *Handler) headerLine() []byte {
+	return []byte("# columns: " + strings.Join(h.Columns(), " ") + "\n")
+}
+
+// writeHeader writes the HeaderLine if it has not been written yet.
+// The caller must hold the mutex, unless the Handler is being created.
+func (h *Handler) writeHeader() error {
+	if h.state.header == nil {
+		return nil
+	}
+	if err := h.writeTimeout(h.state.header, h.opts.Clock()); err != nil {
+		return err
+	}
+	h.state.header = nil
+	return nil
+}
+
+// writeTagSeparator writes the TagSeparator if the tags of the record differ from the tags
+// of the last written record. The caller must hold the mutex.
+func (h *Handler) writeTagSeparator(ctx context.Context, r slog.Record) error {
+	var tags strings.Builder
+	for _, goa := range h.goas {
+		for _, a := range goa.attrs {
+			if isTag(a) {
+				tags.WriteString(a.Value.String())
+				tags.WriteByte(0)
+			}
+		}
+	}
+	if tag, ok := h.derivedTag(ctx, r); ok {
+		tags.WriteString(tag)
+		tags.WriteByte(0)
+	}
+	changed := h.state.hasLastTags && tags.String() != h.state.lastTags
+	h.state.lastTags, h.state.hasLastTags = tags.String(), true
+	if !changed {
+		return nil
+	}
+	return h.writeTimeout([]byte(h.opts.TagSeparator), r.Time)
+}
+
+// write writes the formatted record with the given level and time to the output
+// and calls OnRecord if the write succeeds. The caller must hold the mutex.
+func (h *Handler) write(buf []byte, level slog.Level, t time.Time) error {
+	err := h.writeTimeout(buf, t)
+	if err == nil && h.opts.OnRecord != nil {
+		h.opts.OnRecord(level, len(buf))
+	}
+	return err
+}
+
+// writeTimeout writes the formatted record with the given time to the output, within the WriteTimeout if set.
+func (h *Handler) writeTimeout(buf []byte, t time.Time) error {
+	if h.opts.WriteTimeout <= 0 {
+		return h.writeOut(buf, t)
+	}
+
+	// Drop the record while a timed out write is still blocked.
+	if pending := h.state.pendingWrite; pending != nil {
+		select {
+		case <-pending:
+			h.state.pendingWrite = nil
+		default:
+			return ErrWriteTimeout
+		}
+	}
+
+	// The buffer is reused after Handle returns, so the write needs a copy.
+	p := append([]byte(nil), buf...)
+	done := make(chan struct{})
+	var err error
+	go func() {
+		err = h.writeOut(p, t)
+		close(done)
+	}()
+
+	timer := time.NewTimer(h.opts.WriteTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return err
+	case <-timer.C:
+		h.state.pendingWrite = done
+		return ErrWriteTimeout
+	}
+}
+
+// waitPendingWrite waits up to the WriteTimeout for the write that exceeded it to return,
+// and reports whether no write is pending anymore. The caller must hold the mutex.
+func (h *Handler) waitPendingWrite() bool {
+	pending := h.state.pendingWrite
+	if pending == nil {
+		return true
+	}
+	timer := time.NewTimer(h.opts.WriteTimeout)
+	defer timer.Stop()
+	select {
+	case <-pending:
+		h.state.pendingWrite = nil
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// writeOut writes the formatted record with the given time to the output writer.
+func (h *Handler) writeOut(buf []byte, t time.Time) error {
+	if h.opts.PartitionToken != nil {
+		if pw, ok := h.out.(PartitionWriter); ok {
+			if h.opts.TimeInUTC {
+				t = t.UTC()
+			}
+			_, err := pw.WritePartition(h.opts.PartitionToken(t), buf)
+			return err
+		}
+	}
+	_, err := h.out.Write(buf)
+	return err
+}
+
+// FormatRecord formats the log record the same way as Handle does,
+// but returns the formatted line instead of writing it to the configured io.Writer.
+// The returned slice is owned by the caller.
+func (h *Handler) FormatRecord(ctx context.Context, r slog.Record) ([]byte, error) {
+	h = h.forLevel(r.Level)
+	if h.formatLocked() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+	}
+	if h.opts.DeltaTimestamps {
+		// The delta of the next written record is relative to the last written one.
+		defer func(lastTime time.Time) { h.state.lastTime = lastTime }(h.state.lastTime)
+	}
+	buf, _ := h.appendRecord(nil, ctx, r)
+	return buf, nil
+}
+
+// formatLocked reports whether formatting a record uses the shared handler state
+// and must be done under the mutex.
+func (h *Handler) formatLocked() bool {
+	return h.opts.TabularAttrs || h.opts.DeltaTimestamps
+}
+
+// derivedTag returns the tag set by ContextWithTag, or the package of the caller
+// with AutoTagFromPackage, unless the Handler has tags set by Tag.
+func (h *Handler) derivedTag(ctx context.Context, r slog.Record) (string, bool) {
+	tag, ok := tagFromContext(ctx)
+	if !ok && h.opts.AutoTagFromPackage && r.PC != 0 {
+		tag, ok = packageName(r.PC)
+	}
+	if !ok {
+		return "", false
+	}
+	for _, goa := range h.goas {
+		for _, a := range goa.attrs {
+			if isTag(a) {
+				return "", false
+			}
+		}
+	}
+	return tag, true
+}
+
+// packageName returns the name of the package of the function of the given program counter,
+// which is the last element of the package import path.
+func packageName(pc uintptr) (string, bool) {
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	// The function name is the import path followed by the qualified function name,
+	// e.g. github.com/corvax/slogtfmt.(*Handler).Handle or main.main.func1.
+	name := frame.Function[strings.LastIndexByte(frame.Function, '/')+1:]
+	pkg, _, found := strings.Cut(name, ".")
+	return pkg, found && pkg != ""
+}
+
+// joinedTags returns the tags of the Handler joined with JoinTags, if it has any.
+func (h *Handler) joinedTags() (string, bool) {
+	var tags []string
+	for _, goa := range h.goas {
+		for _, a := range goa.attrs {
+			if isTag(a) {
+				tags = append(tags, a.Value.String())
+			}
+		}
+	}
+	return strings.Join(tags, h.opts.JoinTags), len(tags) > 0
+}
+
+// appendHeaderTag appends a tag header segment: the tag in square brackets,
+// or a field with the TagKey in the FullyKeyed mode.
+func (h *Handler) appendHeaderTag(buf []byte, lineStart int, tag string) []byte {
+	return h.appendBracketedField(buf, lineStart, h.opts.TagKey, tag)
+}
+
+// appendBracketedField appends a header segment with the value in square brackets,
+// or a field with the key in the FullyKeyed mode. The value is quoted if it needs quoting
+// or contains a bracket, so it can't break the columns or forge lines.
+func (h *Handler) appendBracketedField(buf []byte, lineStart int, key, value string) []byte {
+	buf = h.appendHeaderKey(buf, lineStart, key)
+	if h.opts.FullyKeyed {
+		return h.appendHeaderValue(buf, value)
+	}
+	buf = append(buf, "["...)
+	if needsQuoting(value) || strings.ContainsAny(value, "[]") {
+		buf = appendQuoted(buf, value)
+	} else {
+		buf = append(buf, value...)
+	}
+	return append(buf, "]"...)
+}
+
+// appendRecord appends the formatted log record, including the line terminator, to the buffer.
+// It returns the extended buffer and the position of the record body in it.
+func (h *Handler) appendRecord(buf []byte, ctx context.Context, r slog.Record) ([]byte, recordBody) {
+	// Reserve the space for the length prefix.
+	framed := h.opts.FramingMode == FramingLengthPrefix || h.opts.FramingMode == FramingLengthPrefixNewline
+	frameStart := len(buf)
+	if framed {
+		buf = append(buf, 0, 0, 0, 0)
+	}
+	lineStart := len(buf)
+
+	// Append the time.
+	if timeFormat := h.recordTimeFormat(r); timeFormat != "" && !r.Time.IsZero() {
+		buf = h.appendHeaderKey(buf, lineStart, "time")
+		t := r.Time
+		if h.opts.TimeInUTC {
+			t = t.UTC()
+		}
+		if h.opts.FullyKeyed {
+			buf = h.appendHeaderValue(buf, t.Format(timeFormat))
+		} else {
+			timeStart := len(buf)
+			buf = t.AppendFormat(buf, timeFormat)
+			buf = h.fitColumn(buf, timeStart, h.opts.ColumnWidths.Time, false)
+		}
+	} else if timeFormat != "" && h.fixedWidth(h.opts.ColumnWidths.Time) {
+		buf = h.fitColumn(buf, len(buf), h.opts.ColumnWidths.Time, false)
+	}
+
+	// Append the elapsed time. The record time has a monotonic clock reading if it was taken with time.Now.
+	if h.opts.AddElapsed {
+		t := r.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		buf = h.appendHeaderKey(buf, lineStart, "elapsed")
+		buf = h.appendDuration(buf, t.Sub(h.start))
+	}
+
+	// Append the time since the previous record.
+	if h.opts.DeltaTimestamps {
+		t := r.Time
+		if t.IsZero() {
+			t = h.opts.Clock()
+		}
+		var delta time.Duration
+		if !h.state.lastTime.IsZero() {
+			delta = t.Sub(h.state.lastTime)
+		}
+		h.state.lastTime = t
+		buf = h.appendHeaderKey(buf, lineStart, "delta")
+		if delta >= 0 {
+			buf = append(buf, '+')
+		}
+		buf = h.appendDuration(buf, delta)
+	}
+
+	body := recordBody{start: len(buf)}
+
+	if h.opts.TabularAttrs {
+		h.state.tabular.records++
+		h.state.tabular.padStart, h.state.tabular.padEnd = 0, -1
+	}
+
+	// Append the level.
+	buf = h.appendHeaderKey(buf, lineStart, "level")
+	levelStart := len(buf)
+	buf = h.appendLevel(buf, r.Level)
+	levelEnd := len(buf)
+	buf = h.fitColumn(buf, levelStart, h.opts.ColumnWidths.Level, false)
+	levelEnd = min(levelEnd, len(buf))
+
+	// Append the component.
+	if h.opts.Component != "" {
+		buf = h.appendHeaderKey(buf, lineStart, "component")
+		if h.opts.FullyKeyed {
+			buf = h.appendHeaderValue(buf, h.opts.Component)
+		} else {
+			buf = append(buf, h.opts.Component...)
+			for n := utf8.RuneCountInString(h.opts.Component); n < h.opts.ComponentWidth; n++ {
+				buf = append(buf, ' ')
+			}
+		}
+	}
+
+	goas := h.goas
+	// Append the tags. Tags must be set by With(), ContextWithTag or derived with AutoTagFromPackage.
+	// The tags of the Handler are written one by one, unless they are joined into the extra tag.
+	extraTag, hasExtraTag := h.derivedTag(ctx, r)
+	separateTags := h.opts.JoinTags == ""
+	if !separateTags && !hasExtraTag {
+		extraTag, hasExtraTag = h.joinedTags()
+	}
+	if h.opts.TagMode != TagAttr || h.opts.FullyKeyed {
+		tagsStart := len(buf)
+		for _, goa := range goas {
+			for _, a := range goa.attrs {
+				if separateTags && isTag(a) {
+					buf = h.appendHeaderTag(buf, lineStart, a.Value.String())
+				}
+			}
+		}
+		if hasExtraTag {
+			buf = h.appendHeaderTag(buf, lineStart, extraTag)
+		}
+		if h.fixedWidth(h.opts.ColumnWidths.Tag) {
+			// The column starts after the separator preceding the first tag.
+			if len(buf) == tagsStart {
+				buf = h.appendHeaderKey(buf, lineStart, h.opts.TagKey)
+				tagsStart = len(buf)
+			} else if tagsStart > lineStart {
+				tagsStart += len(h.opts.HeaderSeparator)
+			}
+			buf = h.fitColumn(buf, tagsStart, h.opts.ColumnWidths.Tag, false)
+		}
+	}
+
+	// Append the correlation ID.
+	if id, ok := h.correlationID(r); ok {
+		buf = h.appendBracketedField(buf, lineStart, h.opts.CorrelationKey, id)
+	}
+
+	// Append the source.
+	// Records without a program counter, such as the summary of folded records, have no source.
+	if h.opts.AddSource && r.PC != 0 && (h.opts.SourceMinLevel == nil || h.levelEnabled(r.Level, h.opts.SourceMinLevel.Level())) {
+		buf = h.appendHeaderKey(buf, lineStart, "source")
+		if h.opts.FullyKeyed {
+			// The source has no spaces unless the file path has.
+			sourceStart := len(buf)
+			buf = h.appendSource(buf, r.PC)
+			if source := string(buf[sourceStart:]); needsQuoting(source) {
+				buf = appendQuoted(buf[:sourceStart], source)
+			}
+		} else {
+			sourceStart := len(buf)
+			buf = h.appendSource(buf, r.PC)
+			buf = h.fitColumn(buf, sourceStart, h.opts.ColumnWidths.Source, true)
+		}
+	} else if h.opts.AddSource && h.fixedWidth(h.opts.ColumnWidths.Source) {
+		buf = h.appendHeaderKey(buf, lineStart, "source")
+		buf = h.fitColumn(buf, len(buf), h.opts.ColumnWidths.Source, true)
+	}
+
+	// Append the message.
+	msg := r.Message
+	if h.opts.TrimMessage {
+		msg = strings.TrimSpace(msg)
+	}
+	if h.opts.FullyKeyed {
+		buf = append(buf, " msg="...)
+		buf = appendQuoted(buf, msg)
+	} else {
+		buf = append(buf, "\t"...)
+		buf = append(buf, msg...)
+	}
+
+	attrsStart := len(buf)
+
+	// Append the constant attributes.
+	buf = append(buf, h.constAttrs...)
+
+	// Append the tags as attributes, unless they are the header fields.
+	if h.opts.TagMode != TagBracket && !h.opts.FullyKeyed {
+		for _, goa := range goas {
+			for _, a := range goa.attrs {
+				if separateTags && isTag(a) {
+					buf = h.appendLeaf(buf, slog.String(h.opts.TagKey, a.Value.String()), "")
+				}
+			}
+		}
+		if hasExtraTag {
+			buf = h.appendLeaf(buf, slog.String(h.opts.TagKey, extraTag), "")
+		}
+	}
+
+	// Append the groups.
+	if r.NumAttrs() == 0 {
+		// If the record has no Attrs, remove groups at the end of the list
+		for len(goas) > 0 && goas[len(goas)-1].group != "" {
+			goas = goas[:len(goas)-1]
+		}
+	}
+	if h.collectsAttrs() {
+		// Collect the attributes to reorder them before appending.
+		leaves := h.orderAttrs(h.collectAttrs(goas, r))
+		if len(h.opts.PositionalKeys) > 0 {
+			buf, leaves = h.appendPositional(buf, leaves)
+		}
+		for _, leaf := range leaves {
+			buf = h.appendLeaf(buf, leaf.attr, leaf.prefix)
+		}
+	} else {
+		groupPrefix := ""
+		for _, goa := range goas {
+			if goa.group != "" {
+				groupPrefix = h.groupPrefix(groupPrefix, h.alias(goa.group))
+			}
+			for _, a := range goa.attrs {
+				if !isTag(a) && a.Key != timeFormatKeyName && !h.isCorrelationID(a, groupPrefix) {
+					buf = h.appendAttr(buf, a, groupPrefix, 0)
+				}
+			}
+		}
+
+		// Append the attributes.
+		r.Attrs(func(attr slog.Attr) bool {
+			if attr.Key != timeFormatKeyName && !h.isCorrelationID(attr, groupPrefix) {
+				buf = h.appendAttr(buf, attr, groupPrefix, 0)
+			}
+			return true
+		})
+	}
+
+	if h.opts.TabularAttrs {
+		// Remove the padding of the last attribute.
+		buf = h.state.tabular.trim(buf)
+	}
 
-	buf = append(buf, "\n"...)
+	// Wrap the attributes, which start with the attribute separator, including the positional values, in the delimiters.
+	if h.opts.AttrsBrackets && len(buf) > attrsStart {
+		buf = slices.Insert(buf, attrsStart+len(h.attrSeparator()), []byte(h.opts.AttrsDelimiters[0])...)
+		buf = append(buf, h.opts.AttrsDelimiters[1]...)
+	}
 
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	_, err := h.out.Write(buf)
-	return err
+	body.end = len(buf)
+
+	// Append the ID of the record.
+	if h.opts.AddRecordID {
+		buf = appendRecordID(buf, buf[levelStart:levelEnd], msg, buf[attrsStart:body.end])
+	}
+
+	// Append the checksum of the line.
+	if h.opts.AddChecksum {
+		buf = appendChecksum(buf, lineStart)
+	}
+
+	if h.opts.FramingMode != FramingLengthPrefix {
+		buf = append(buf, "\n"...)
+	}
+	if framed {
+		binary.BigEndian.PutUint32(buf[frameStart:], uint32(len(buf)-lineStart))
+	}
+	return buf, body
 }
 
 // WithGroup returns a new Handler that will log all records with the given group name.
@@ -268,91 +2274,762 @@ func (h *Handler) WithGroup(name string) slog.Handler {
 	if name == "" {
 		return h
 	}
-	return h.withGroupOrAttrs(groupOrAttrs{group: name})
+	h2 := h.withGroupOrAttrs(groupOrAttrs{group: name})
+	if h.strictKeys() && h.ambiguousKey(name) {
+		h2.keyErrs = append(slices.Clip(h.keyErrs),
+			fmt.Errorf("%w: group %q contains %q", ErrInvalidKey, name, h.opts.GroupSeparator))
+	}
+	h2.updateLevels()
+	return h2
 }
 
 // WithAttrs returns a new Handler that will log all records with the given attributes.
 // If the list of attributes is empty, the original Handler is returned.
+// Consecutive WithAttrs calls are merged into a single list of attributes,
+// so loggers built with many With calls don't add per-record overhead.
 func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	if len(attrs) == 0 {
 		return h
 	}
-	return h.withGroupOrAttrs(groupOrAttrs{attrs: attrs})
+	var keyErrs []error
+	if h.strictKeys() {
+		// Resolve and check the attributes once instead of for every record.
+		attrs = slices.Clone(attrs)
+		keyErrs = slices.Clip(h.keyErrs)
+		for i, a := range attrs {
+			attrs[i] = h.resolveAttr(a, 0)
+			if !isTag(a) && a.Key != timeFormatKeyName {
+				keyErrs = h.checkKey(keyErrs, attrs[i], 0)
+			}
+		}
+	}
+
+	var h2 *Handler
+	if n := len(h.goas); n > 0 && h.goas[n-1].group == "" {
+		last := h.goas[n-1].attrs
+		merged := make([]slog.Attr, 0, len(last)+len(attrs))
+		merged = append(merged, last...)
+		merged = append(merged, attrs...)
+
+		c := *h
+		c.goas = make([]groupOrAttrs, n)
+		copy(c.goas, h.goas)
+		c.goas[n-1] = groupOrAttrs{attrs: merged}
+		h2 = &c
+	} else {
+		h2 = h.withGroupOrAttrs(groupOrAttrs{attrs: attrs})
+	}
+	if keyErrs != nil {
+		h2.keyErrs = keyErrs
+	}
+	h2.updateLevels()
+	return h2
+}
+
+// strictKeys reports whether StrictKeys is set for any level.
+func (h *Handler) strictKeys() bool {
+	if h.opts.StrictKeys {
+		return true
+	}
+	for _, v := range h.levels {
+		if v.handler.opts.StrictKeys {
+			return true
+		}
+	}
+	return false
 }
 
 // appendAttr appends the given attribute to the provided buffer, with the given prefix.
 // It handles different attribute value types, including strings, times, and attribute groups.
-// Attributes with empty values are ignored.
-func (h *Handler) appendAttr(buf []byte, attr slog.Attr, prefix string) []byte {
+// Attributes with empty values are ignored. The depth is the number of groups in the attribute
+// value enclosing the attribute.
+func (h *Handler) appendAttr(buf []byte, attr slog.Attr, prefix string, depth int) []byte {
 	// Resolve the Attr's value before doing anything else.
-	attr.Value = attr.Value.Resolve()
+	attr.Value = h.resolve(attr.Value)
+	attr.Key = h.alias(attr.Key)
 
 	// Ignore empty attrs.
 	if attr.Equal(slog.Attr{}) {
 		return buf
 	}
 
+	if attr.Value.Kind() == slog.KindGroup {
+		attrs := attr.Value.Group()
+
+		// Ignore empty groups, unless they are written as empty values.
+		if len(attrs) == 0 {
+			if h.opts.EmitEmpty && attr.Key != "" {
+				return h.appendLeaf(buf, attr, prefix)
+			}
+			return buf
+		}
+
+		if h.groupTooDeep(depth) {
+			prefix, key := h.truncatedGroupKey(prefix, attr.Key)
+			return h.appendLeaf(buf, slog.Any(key, truncatedGroup{}), prefix)
+		}
+
+		// If the Key is not empty, write it out.
+		if attr.Key != "" {
+			prefix = h.groupPrefix(prefix, attr.Key)
+		}
+
+		for _, a := range attrs {
+			buf = h.appendAttr(buf, a, prefix, depth+1)
+		}
+		return buf
+	}
+
+	return h.appendLeaf(buf, attr, prefix)
+}
+
+// groupTooDeep reports whether a group at the given depth exceeds MaxGroupDepth.
+func (h *Handler) groupTooDeep(depth int) bool {
+	return h.opts.MaxGroupDepth > 0 && depth >= h.opts.MaxGroupDepth
+}
+
+// truncatedGroupKey returns the prefix and the key of the placeholder of a group deeper than
+// MaxGroupDepth. A group without a key is written under the key of the enclosing group,
+// because its attributes belong to that group, or under the key !BADKEY of log/slog if it's
+// only enclosed by groups without a key.
+func (h *Handler) truncatedGroupKey(prefix, key string) (string, string) {
+	if key != "" {
+		return prefix, key
+	}
+	if prefix == "" {
+		return "", badKey
+	}
+	// The prefix is already escaped, so it's used as is with an empty key.
+	return strings.TrimSuffix(prefix, h.opts.GroupSeparator), ""
+}
+
+// badKey is the key used by log/slog for the values without a key.
+const badKey = "!BADKEY"
+
+// truncatedGroup is the value of the groups deeper than MaxGroupDepth.
+type truncatedGroup struct{}
+
+// String returns the placeholder of the truncated group.
+func (truncatedGroup) String() string {
+	return "{...}"
+}
+
+// alias returns the alias of the key from KeyAliases, or the key itself.
+func (h *Handler) alias(key string) string {
+	if alias, ok := h.opts.KeyAliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// resolve resolves the value and applies the TypeFormatters.
+func (h *Handler) resolve(v slog.Value) (resolved slog.Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			resolved = h.panicValue(r)
+		}
+	}()
+	// Unlike Value.Resolve, which turns a panic into an error value with the stack trace,
+	// the LogValue panics are recovered with the other panics above.
+	for i := 0; v.Kind() == slog.KindLogValuer; i++ {
+		if i == maxLogValuerDepth {
+			return slog.AnyValue(fmt.Errorf("LogValue called too many times on Value of type %T", v.Any()))
+		}
+		v = v.LogValuer().LogValue()
+	}
+	if v.Kind() == slog.KindAny {
+		for _, format := range h.opts.TypeFormatters {
+			if fv, ok := format(v.Any()); ok {
+				return fv.Resolve()
+			}
+		}
+	}
+	return v
+}
+
+// maxLogValuerDepth is the maximum number of LogValue calls to resolve a value, like in Value.Resolve.
+const maxLogValuerDepth = 100
+
+// panicValue returns the placeholder of a value whose formatting panicked and reports the panic to OnError.
+func (h *Handler) panicValue(r any) slog.Value {
+	if h.opts.OnError != nil {
+		h.opts.OnError(fmt.Errorf("%w: %v", ErrAttrPanic, r))
+	}
+	return slog.StringValue(fmt.Sprintf("!PANIC(%v)", r))
+}
+
+// appendLeaf appends the resolved non-group attribute to the buffer, with the given prefix.
+func (h *Handler) appendLeaf(buf []byte, attr slog.Attr, prefix string) []byte {
+	return h.appendLeafValue(buf, attr, prefix, true)
+}
+
+// appendLeafValue appends the resolved non-group attribute to the buffer, with the given prefix,
+// or only its value preceded by the attribute separator if keyed is false, as for PositionalKeys.
+// With ExpandErrorChain, the cause of an error value is only appended if keyed is set.
+func (h *Handler) appendLeafValue(buf []byte, attr slog.Attr, prefix string, keyed bool) (result []byte) {
+	start := len(buf)
+	defer func() {
+		if r := recover(); r != nil {
+			result = h.appendLeafValue(buf[:start], slog.Attr{Key: attr.Key, Value: h.panicValue(r)}, prefix, keyed)
+		}
+	}()
+
+	var err error
+	if h.opts.ExpandErrorChain && attr.Value.Kind() == slog.KindAny {
+		err, _ = attr.Value.Any().(error)
+	}
+	if err != nil && keyed {
+		return h.appendError(buf, err, prefix, attr.Key)
+	}
+
+	if keyed {
+		buf = h.appendKey(buf, prefix, attr.Key)
+	} else {
+		buf = append(buf, h.attrSeparator()...)
+	}
+	valueStart := len(buf)
+	if err != nil {
+		buf = h.appendString(buf, err.Error())
+	} else {
+		buf = h.appendValue(buf, attr.Value)
+	}
+	if h.opts.InferUnits {
+		buf = h.appendUnit(buf, attr)
+	}
+	if h.opts.TabularAttrs {
+		return h.state.tabular.pad(buf, prefix+attr.Key, valueStart)
+	}
+	return buf
+}
+
+// appendUnit appends the unit of the numeric attribute value inferred from the key suffix.
+// Nothing is appended to the other values, including NaN and the infinities, which are
+// not plain numbers.
+func (h *Handler) appendUnit(buf []byte, attr slog.Attr) []byte {
 	switch attr.Value.Kind() {
+	case slog.KindInt64, slog.KindUint64:
+	case slog.KindFloat64:
+		if f := attr.Value.Float64(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return buf
+		}
+	default:
+		return buf
+	}
+	var suffix, unit string
+	for s, u := range h.opts.UnitSuffixes {
+		if len(s) > len(suffix) && strings.HasSuffix(attr.Key, s) {
+			suffix, unit = s, u
+		}
+	}
+	return append(buf, unit...)
+}
+
+// appendError appends the error message and, if the error wraps other errors,
+// the message of the innermost error as the <key>.cause attribute.
+func (h *Handler) appendError(buf []byte, err error, prefix, key string) []byte {
+	buf = h.appendKey(buf, prefix, key)
+	buf = h.appendString(buf, err.Error())
+
+	if cause := innermostError(err); cause != err {
+		buf = h.appendKey(buf, h.groupPrefix(prefix, key), "cause")
+		buf = h.appendString(buf, cause.Error())
+	}
+	return buf
+}
+
+// innermostError returns the innermost error of the chain unwrapped with errors.Unwrap.
+func innermostError(err error) error {
+	for next := errors.Unwrap(err); next != nil; next = errors.Unwrap(err) {
+		err = next
+	}
+	return err
+}
+
+// appendKey appends the separator preceding an attribute and the attribute key with the given prefix,
+// followed by the key-value separator.
+func (h *Handler) appendKey(buf []byte, prefix, key string) []byte {
+	buf = append(buf, h.attrSeparator()...)
+	buf = append(buf, h.opts.KeyNamespace...)
+	buf = append(buf, prefix...)
+	buf = h.appendEscapedKey(buf, key)
+	return append(buf, h.opts.KeyValueSeparator...)
+}
+
+// attrSeparator returns the separator preceding an attribute: a space,
+// or a line break and an indent with MultilineAttrs.
+func (h *Handler) attrSeparator() string {
+	if h.opts.MultilineAttrs {
+		return "\n\t"
+	}
+	return " "
+}
+
+// groupPrefix returns the key prefix of the attributes of the group with the given name
+// nested in the group with the given prefix.
+func (h *Handler) groupPrefix(prefix, name string) string {
+	return prefix + h.escapeKey(name) + h.opts.GroupSeparator
+}
+
+// escapeKey returns the key with the group separator and backslashes escaped if EscapeKeys is set.
+func (h *Handler) escapeKey(key string) string {
+	if !h.opts.EscapeKeys || !h.needsEscaping(key) {
+		return key
+	}
+	return string(h.appendEscapedKey(nil, key))
+}
+
+// appendEscapedKey appends the key with the group separator and backslashes escaped if EscapeKeys is set.
+func (h *Handler) appendEscapedKey(buf []byte, key string) []byte {
+	if !h.opts.EscapeKeys || !h.needsEscaping(key) {
+		return append(buf, key...)
+	}
+	sep := h.opts.GroupSeparator
+	for i := 0; i < len(key); {
+		switch {
+		case key[i] == '\\':
+			buf = append(buf, `\\`...)
+			i++
+		case strings.HasPrefix(key[i:], sep):
+			buf = append(buf, '\\')
+			buf = append(buf, sep...)
+			i += len(sep)
+		default:
+			buf = append(buf, key[i])
+			i++
+		}
+	}
+	return buf
+}
+
+// needsEscaping reports whether the key contains the group separator or a backslash.
+func (h *Handler) needsEscaping(key string) bool {
+	return strings.Contains(key, h.opts.GroupSeparator) || strings.IndexByte(key, '\\') >= 0
+}
+
+// appendValue appends the resolved non-group value to the buffer.
+func (h *Handler) appendValue(buf []byte, v slog.Value) []byte {
+	switch v.Kind() {
 	case slog.KindString:
-		buf = append(buf, " "...)
-		buf = append(buf, prefix+attr.Key...)
-		buf = append(buf, "="...)
-		buf = strconv.AppendQuote(buf, attr.Value.String())
+		return h.appendString(buf, v.String())
 	case slog.KindTime:
-		buf = append(buf, " "...)
-		buf = append(buf, prefix+attr.Key...)
-		buf = append(buf, "="...)
+		if h.opts.RelativeTimeAttrs && !v.Time().IsZero() {
+			return h.appendRelativeTime(buf, v.Time())
+		}
 		if h.opts.TimeAttributeInUTC {
-			buf = append(buf, attr.Value.Time().UTC().Format(h.opts.TimeAttributeFormat)...)
-		} else {
-			buf = append(buf, attr.Value.Time().Format(h.opts.TimeAttributeFormat)...)
+			return append(buf, v.Time().UTC().Format(h.opts.TimeAttributeFormat)...)
 		}
+		return append(buf, v.Time().Format(h.opts.TimeAttributeFormat)...)
 	case slog.KindBool:
-		buf = append(buf, " "...)
-		buf = append(buf, prefix+attr.Key...)
-		buf = append(buf, "="...)
-		buf = strconv.AppendBool(buf, attr.Value.Bool())
+		if v.Bool() {
+			return append(buf, h.opts.Vocabulary.TrueText...)
+		}
+		return append(buf, h.opts.Vocabulary.FalseText...)
 	case slog.KindDuration:
-		buf = append(buf, " "...)
-		buf = append(buf, prefix+attr.Key...)
-		buf = append(buf, "="...)
-		buf = append(buf, attr.Value.Duration().String()...)
+		return h.appendDuration(buf, v.Duration())
 	case slog.KindInt64:
-		buf = append(buf, " "...)
-		buf = append(buf, prefix+attr.Key...)
-		buf = append(buf, "="...)
-		buf = strconv.AppendInt(buf, attr.Value.Int64(), 10)
+		return strconv.AppendInt(buf, v.Int64(), 10)
 	case slog.KindUint64:
-		buf = append(buf, " "...)
-		buf = append(buf, prefix+attr.Key...)
-		buf = append(buf, "="...)
-		buf = strconv.AppendUint(buf, attr.Value.Uint64(), 10)
-	case slog.KindFloat64:
-		buf = append(buf, " "...)
-		buf = append(buf, prefix+attr.Key...)
-		buf = append(buf, "="...)
-		buf = strconv.AppendFloat(buf, attr.Value.Float64(), 'f', -1, 64)
+		return strconv.AppendUint(buf, v.Uint64(), 10)
 	case slog.KindGroup:
-		attrs := attr.Value.Group()
+		// Only empty groups are written as values.
+		return append(buf, "{}"...)
+	case slog.KindFloat64:
+		return h.appendFloat(buf, v.Float64())
+	default:
+		if v.Kind() == slog.KindAny {
+			switch v.Any().(type) {
+			case nil:
+				return append(buf, h.opts.Vocabulary.NilText...)
+			case truncatedGroup:
+				return append(buf, "{...}"...)
+			}
+			if h.opts.OpaqueValues {
+				switch kind := reflect.TypeOf(v.Any()).Kind(); kind {
+				case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+					buf = append(buf, kind.String()...)
+					return append(buf, "(...)"...)
+				}
+			}
+		}
+		return append(buf, v.String()...)
+	}
+}
 
-		// Ignore empty groups.
-		if len(attrs) == 0 {
+// recordTimeFormat returns the timestamp format for the record.
+// A TimeFormatOverride attribute of the record takes precedence over one set with With(),
+// which takes precedence over the configured TimeFormat.
+func (h *Handler) recordTimeFormat(r slog.Record) string {
+	timeFormat := h.opts.TimeFormat
+	for _, goa := range h.goas {
+		for _, a := range goa.attrs {
+			if a.Key == timeFormatKeyName {
+				timeFormat = a.Value.String()
+			}
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == timeFormatKeyName {
+			timeFormat = a.Value.String()
+			return false
+		}
+		return true
+	})
+	return timeFormat
+}
+
+// appendSource appends the source location of the given program counter as file:line.
+// If SourceFrames is greater than 1, the callers of the location are appended as well.
+func (h *Handler) appendSource(buf []byte, pc uintptr) []byte {
+	pcs := []uintptr{pc}
+	if h.opts.SourceFrames > 1 {
+		pcs = callerChain(pc, min(h.opts.SourceFrames, MaxSourceFrames))
+	}
+
+	frames := runtime.CallersFrames(pcs)
+	for i := 0; ; i++ {
+		frame, more := frames.Next()
+		if i > 0 {
+			buf = append(buf, ";"...)
+		}
+		buf = append(buf, h.sourceFile(frame.File)...)
+		buf = append(buf, ":"...)
+		buf = strconv.AppendInt(buf, int64(frame.Line), 10)
+		if !more {
 			return buf
 		}
+	}
+}
 
-		// If the Key is not empty, write it out.
-		if attr.Key != "" {
-			prefix = prefix + attr.Key + "."
+// sourceFile returns the file path relative to SourceRoot, or the full path
+// if SourceRoot is not set or the file is outside of it, or the file URI with SourceAsURI.
+func (h *Handler) sourceFile(file string) string {
+	if h.opts.SourceAsURI {
+		return fileURI(file)
+	}
+	if h.opts.SourceRoot == "" {
+		return file
+	}
+	rel, err := filepath.Rel(h.opts.SourceRoot, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return file
+	}
+	return filepath.ToSlash(rel)
+}
+
+// fileURI returns the file URI of the file path, made absolute if needed.
+func fileURI(file string) string {
+	if !filepath.IsAbs(file) {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
 		}
+	}
+	path := filepath.ToSlash(file)
+	if !strings.HasPrefix(path, "/") {
+		// Windows paths, e.g. C:/app/main.go.
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
 
-		for _, a := range attrs {
-			buf = h.appendAttr(buf, a, prefix)
+// callerChain returns up to n program counters of the current call stack starting at pc.
+// If pc is not on the current call stack, only pc is returned.
+func callerChain(pc uintptr, n int) []uintptr {
+	// Leave room for the frames between the log statement and the Handler,
+	// such as slog.Logger methods and wrapping handlers.
+	pcs := make([]uintptr, n+64)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	for i, p := range pcs {
+		if p == pc {
+			return pcs[i:min(i+n, len(pcs))]
+		}
+	}
+	return []uintptr{pc}
+}
+
+// appendLevel appends the level to the buffer according to the configured LevelFormat.
+func (h *Handler) appendLevel(buf []byte, level slog.Level) []byte {
+	switch h.opts.LevelFormat {
+	case LevelNumeric:
+		return strconv.AppendInt(buf, int64(level), 10)
+	case LevelNumericText:
+		buf = strconv.AppendInt(buf, int64(level), 10)
+		buf = append(buf, ":"...)
+		return append(buf, h.levelLabel(level)...)
+	case LevelSyslog:
+		return strconv.AppendInt(buf, int64(SyslogSeverity(level)), 10)
+	default:
+		return append(buf, h.levelLabel(level)...)
+	}
+}
+
+// levelLabel returns the name of the level from LevelLabels, or the slog name of the level.
+func (h *Handler) levelLabel(level slog.Level) string {
+	if label, ok := h.opts.LevelLabels[level]; ok {
+		return label
+	}
+	return level.String()
+}
+
+// appendString appends the string value to the buffer, quoted unless it consists of SafeRunes.
+func (h *Handler) appendString(buf []byte, s string) []byte {
+	if h.opts.SafeRunes != nil && h.isSafe(s) {
+		return append(buf, s...)
+	}
+	return appendQuoted(buf, s)
+}
+
+// appendQuoted appends the string quoted like strconv.AppendQuote. Strings of printable ASCII
+// characters without quotes and backslashes, which are the most common, are copied as is.
+func appendQuoted(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '"' || c == '\\' {
+			return strconv.AppendQuote(buf, s)
+		}
+	}
+	buf = append(buf, '"')
+	buf = append(buf, s...)
+	return append(buf, '"')
+}
+
+// isSafe reports whether the string can be written unquoted.
+func (h *Handler) isSafe(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r == ' ' || r == '"' || r == '\\' || !unicode.IsPrint(r) || !h.opts.SafeRunes(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendDuration appends the duration to the buffer according to the configured DurationFormat.
+func (h *Handler) appendDuration(buf []byte, d time.Duration) []byte {
+	switch h.opts.DurationFormat {
+	case DurationSeconds:
+		return h.appendFloat(buf, d.Seconds())
+	case DurationISO8601:
+		return appendISODuration(buf, d)
+	default:
+		return append(buf, d.String()...)
+	}
+}
+
+// appendISODuration appends the duration in the ISO 8601 format, e.g. PT1M1.5S.
+func appendISODuration(buf []byte, d time.Duration) []byte {
+	// Use an unsigned value, so the minimum duration can be negated.
+	u := uint64(d)
+	if d < 0 {
+		buf = append(buf, '-')
+		u = -u
+	}
+	buf = append(buf, "PT"...)
+	if u == 0 {
+		return append(buf, "0S"...)
+	}
+
+	hours := u / uint64(time.Hour)
+	minutes := u / uint64(time.Minute) % 60
+	seconds := u / uint64(time.Second) % 60
+	nanos := u % uint64(time.Second)
+	if hours > 0 {
+		buf = strconv.AppendUint(buf, hours, 10)
+		buf = append(buf, 'H')
+	}
+	if minutes > 0 {
+		buf = strconv.AppendUint(buf, minutes, 10)
+		buf = append(buf, 'M')
+	}
+	if seconds > 0 || nanos > 0 {
+		buf = strconv.AppendUint(buf, seconds, 10)
+		if nanos > 0 {
+			// Write the nanoseconds zero-padded to 9 digits, without the trailing zeros.
+			var frac [9]byte
+			for i := len(frac) - 1; i >= 0; i-- {
+				frac[i] = '0' + byte(nanos%10)
+				nanos /= 10
+			}
+			n := len(frac)
+			for frac[n-1] == '0' {
+				n--
+			}
+			buf = append(buf, '.')
+			buf = append(buf, frac[:n]...)
 		}
+		buf = append(buf, 'S')
+	}
+	return buf
+}
+
+// appendRelativeTime appends the quoted time relative to the current time of the Clock,
+// in the largest whole unit, e.g. "3s ago" or "in 5m".
+func (h *Handler) appendRelativeTime(buf []byte, t time.Time) []byte {
+	now := h.opts.Clock()
+	d := t.Sub(now)
+	future := d > 0
+	if !future {
+		d = -d
+	}
+	if d < 0 {
+		// The difference saturated at the minimum duration, whose negation overflows.
+		d = math.MaxInt64
+	}
+
+	var n int64
+	var unit string
+	switch {
+	case d < time.Second:
+		return append(buf, `"now"`...)
+	case d < time.Minute:
+		n, unit = int64(d/time.Second), "s"
+	case d < time.Hour:
+		n, unit = int64(d/time.Minute), "m"
+	case d < 24*time.Hour:
+		n, unit = int64(d/time.Hour), "h"
 	default:
-		buf = append(buf, " "...)
-		buf = append(buf, prefix+attr.Key...)
-		buf = append(buf, "="...)
-		buf = append(buf, attr.Value.String()...)
+		// The days are counted in seconds, because the duration saturates after about 292 years.
+		n, unit = (now.Unix()-t.Unix())/(24*60*60), "d"
+		if future {
+			n = -n
+		}
+	}
+
+	buf = append(buf, '"')
+	if future {
+		buf = append(buf, "in "...)
+	}
+	buf = strconv.AppendInt(buf, n, 10)
+	buf = append(buf, unit...)
+	if !future {
+		buf = append(buf, " ago"...)
+	}
+	return append(buf, '"')
+}
+
+// appendFloat appends the float value to the buffer according to FloatSpecials and FloatPrecision,
+// using the shortest representation that preserves the value by default.
+func (h *Handler) appendFloat(buf []byte, f float64) []byte {
+	if h.opts.FloatSpecials != FloatSpecialsLiteral && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		if h.opts.FloatSpecials == FloatSpecialsNull {
+			return append(buf, "null"...)
+		}
+		return strconv.AppendQuote(buf, strconv.FormatFloat(f, 'f', -1, 64))
+	}
+	if h.opts.FloatPrecision > 0 {
+		return strconv.AppendFloat(buf, f, 'f', h.opts.FloatPrecision, 64)
+	}
+	return strconv.AppendFloat(buf, f, 'f', -1, 64)
+}
+
+// appendChecksum appends the checksum field with the CRC-32 of the line content starting at lineStart.
+func appendChecksum(buf []byte, lineStart int) []byte {
+	const hexDigits = "0123456789abcdef"
+	sum := crc32.ChecksumIEEE(buf[lineStart:])
+	buf = append(buf, " checksum="...)
+	for shift := 28; shift >= 0; shift -= 4 {
+		buf = append(buf, hexDigits[(sum>>shift)&0xf])
+	}
+	return buf
+}
+
+// appendHeaderKey appends the separator preceding a segment of the header, unless it's the first
+// segment of the line, or in the FullyKeyed mode, the key of the segment.
+func (h *Handler) appendHeaderKey(buf []byte, lineStart int, key string) []byte {
+	if h.opts.FullyKeyed {
+		if len(buf) > lineStart {
+			buf = append(buf, ' ')
+		}
+		buf = append(buf, key...)
+		return append(buf, h.opts.KeyValueSeparator...)
+	}
+	if len(buf) > lineStart {
+		buf = append(buf, h.opts.HeaderSeparator...)
+	}
+	return buf
+}
+
+// fixedWidth reports whether a header column with the given width from ColumnWidths has a fixed width.
+func (h *Handler) fixedWidth(width int) bool {
+	return width > 0 && !h.opts.FullyKeyed
+}
+
+// fitColumn pads the header column starting at start with spaces to the given width in runes,
+// or truncates it to the width, keeping the end of the column if keepEnd is set.
+// The column is left as it is if the width is not fixed.
+func (h *Handler) fitColumn(buf []byte, start, width int, keepEnd bool) []byte {
+	if !h.fixedWidth(width) {
+		return buf
+	}
+	n := utf8.RuneCount(buf[start:])
+	if n <= width {
+		for ; n < width; n++ {
+			buf = append(buf, ' ')
+		}
+		return buf
+	}
+
+	// Skip the runes to drop at the start, or the runes to keep.
+	skip := width
+	if keepEnd {
+		skip = n - width
+	}
+	i := start
+	for ; skip > 0; skip-- {
+		_, size := utf8.DecodeRune(buf[i:])
+		i += size
+	}
+	if keepEnd {
+		return append(buf[:start], buf[i:]...)
+	}
+	return buf[:i]
+}
+
+// appendHeaderValue appends the value of a header field in the FullyKeyed mode, quoted if needed.
+func (h *Handler) appendHeaderValue(buf []byte, s string) []byte {
+	if needsQuoting(s) {
+		return appendQuoted(buf, s)
+	}
+	return append(buf, s...)
+}
+
+// needsQuoting reports whether the value of a header field must be quoted to be parsed as logfmt.
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r == ' ' || r == '"' || r == '=' || r == '\\' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendRecordID appends the record_id field with the FNV-1a hash of the level, message and attributes.
+func appendRecordID(buf, level []byte, msg string, attrs []byte) []byte {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	sum := uint64(offset64)
+	for _, b := range level {
+		sum = (sum ^ uint64(b)) * prime64
+	}
+	// Separate the parts, so moving bytes between them changes the hash.
+	sum *= prime64
+	for i := 0; i < len(msg); i++ {
+		sum = (sum ^ uint64(msg[i])) * prime64
+	}
+	sum *= prime64
+	for _, b := range attrs {
+		sum = (sum ^ uint64(b)) * prime64
+	}
+
+	const hexDigits = "0123456789abcdef"
+	buf = append(buf, " record_id="...)
+	for shift := 60; shift >= 0; shift -= 4 {
+		buf = append(buf, hexDigits[(sum>>shift)&0xf])
 	}
 	return buf
 }
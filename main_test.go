@@ -2,9 +2,22 @@ package slogtfmt
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"log/slog"
+	"math"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+	"unicode"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -92,3 +105,2526 @@ func BenchmarkHandler(b *testing.B) {
 		)
 	}
 }
+
+func TestHandlerDurationFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   DurationFormat
+		value    time.Duration
+		expected string
+	}{
+		{"String", DurationString, 1500 * time.Millisecond, "INFO\tmsg d=1.5s\n"},
+		{"Seconds", DurationSeconds, 1500 * time.Millisecond, "INFO\tmsg d=1.5\n"},
+		{"Seconds sub-millisecond", DurationSeconds, 250 * time.Microsecond, "INFO\tmsg d=0.00025\n"},
+		{"Seconds nanosecond", DurationSeconds, time.Nanosecond, "INFO\tmsg d=0.000000001\n"},
+		{"Seconds zero", DurationSeconds, 0, "INFO\tmsg d=0\n"},
+		{"ISO 8601", DurationISO8601, 61 * time.Second, "INFO\tmsg d=PT1M1S\n"},
+		{"ISO 8601 hours", DurationISO8601, 90 * time.Minute, "INFO\tmsg d=PT1H30M\n"},
+		{"ISO 8601 days", DurationISO8601, 50*time.Hour + 5*time.Second, "INFO\tmsg d=PT50H5S\n"},
+		{"ISO 8601 fraction", DurationISO8601, 1500 * time.Millisecond, "INFO\tmsg d=PT1.5S\n"},
+		{"ISO 8601 sub-second", DurationISO8601, 250 * time.Microsecond, "INFO\tmsg d=PT0.00025S\n"},
+		{"ISO 8601 zero", DurationISO8601, 0, "INFO\tmsg d=PT0S\n"},
+		{"ISO 8601 negative", DurationISO8601, -(61*time.Second + time.Nanosecond), "INFO\tmsg d=-PT1M1.000000001S\n"},
+		{"ISO 8601 minimum", DurationISO8601, math.MinInt64, "INFO\tmsg d=-PT2562047H47M16.854775808S\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithDurationFormat(tt.format))
+			slog.New(handler).Info("msg", slog.Duration("d", tt.value))
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func TestHandlerConstantAttrs(t *testing.T) {
+	attrs := []slog.Attr{
+		slog.String("service", "api"),
+		slog.String("version", "1.2.3"),
+		slog.Group("build", slog.Int("number", 42)),
+	}
+
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithConstantAttrs(attrs...))
+	logger := slog.New(handler)
+
+	logger.Info("Started", "key", "value")
+	expected := "INFO\tStarted service=\"api\" version=\"1.2.3\" build.number=42 key=\"value\"\n"
+	assert.Equal(t, expected, buf.String())
+
+	// The output must be the same as when the attributes are added with WithAttrs.
+	var buf2 bytes.Buffer
+	handler2 := NewHandlerWithOptions(&buf2, WithTimeFormat(""))
+	slog.New(handler2.WithAttrs(attrs)).Info("Started", "key", "value")
+	assert.Equal(t, buf2.String(), buf.String())
+
+	// Constant attributes are not affected by groups.
+	buf.Reset()
+	logger.WithGroup("g").Info("Started", "key", "value")
+	expected = "INFO\tStarted service=\"api\" version=\"1.2.3\" build.number=42 g.key=\"value\"\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func BenchmarkHandlerConstantAttrs(b *testing.B) {
+	attrs := []slog.Attr{
+		slog.String("service", "api"),
+		slog.String("version", "1.2.3"),
+		slog.Int("pid", 1234),
+	}
+
+	b.Run("ConstantAttrs", func(b *testing.B) {
+		var buf bytes.Buffer
+		logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithConstantAttrs(attrs...)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			logger.Info("benchmark message", "key", "value")
+		}
+	})
+
+	b.Run("WithAttrs", func(b *testing.B) {
+		var buf bytes.Buffer
+		logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat("")).WithAttrs(attrs))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			logger.Info("benchmark message", "key", "value")
+		}
+	})
+}
+
+// partitionBuffer is a PartitionWriter that collects records by partition token.
+type partitionBuffer struct {
+	partitions map[string]*bytes.Buffer
+}
+
+func (p *partitionBuffer) Write(b []byte) (int, error) {
+	return p.WritePartition("", b)
+}
+
+func (p *partitionBuffer) WritePartition(token string, b []byte) (int, error) {
+	if p.partitions[token] == nil {
+		p.partitions[token] = &bytes.Buffer{}
+	}
+	return p.partitions[token].Write(b)
+}
+
+func TestHandlerPartitionToken(t *testing.T) {
+	out := &partitionBuffer{partitions: map[string]*bytes.Buffer{}}
+	handler := NewHandlerWithOptions(out,
+		WithTimeFormat(time.TimeOnly),
+		WithTimeInUTC(true),
+		WithPartitionToken(DatePartition),
+	)
+
+	beforeMidnight := time.Date(2024, 6, 1, 23, 59, 59, 0, time.UTC)
+	afterMidnight := beforeMidnight.Add(2 * time.Second)
+
+	for _, tm := range []time.Time{beforeMidnight, afterMidnight} {
+		r := slog.NewRecord(tm, slog.LevelInfo, "msg", 0)
+		assert.NoError(t, handler.Handle(context.Background(), r))
+	}
+
+	assert.Len(t, out.partitions, 2)
+	assert.Equal(t, "23:59:59\tINFO\tmsg\n", out.partitions["2024-06-01"].String())
+	assert.Equal(t, "00:00:01\tINFO\tmsg\n", out.partitions["2024-06-02"].String())
+}
+
+func TestHandlerPartitionTokenPlainWriter(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithPartitionToken(DatePartition))
+	slog.New(handler).Info("msg")
+	assert.Equal(t, "INFO\tmsg\n", buf.String())
+}
+
+func TestHandlerHeaderSeparator(t *testing.T) {
+	for _, withTag := range []bool{false, true} {
+		for _, addSource := range []bool{false, true} {
+			var buf bytes.Buffer
+			handler := NewHandlerWithOptions(&buf,
+				WithTimeFormat(time.DateOnly),
+				WithAddSource(addSource),
+				WithHeaderSeparator(" "),
+			)
+			logger := slog.New(handler)
+			if withTag {
+				logger = logger.With(Tag("tag"))
+			}
+			logger.Info("test message", "key", "value")
+
+			line := buf.String()
+			assert.Equal(t, 1, strings.Count(line, "\t"), "tag=%v source=%v: %q", withTag, addSource, line)
+			assert.True(t, strings.HasSuffix(line, "\ttest message key=\"value\"\n"), line)
+			assert.Equal(t, withTag, strings.Contains(line, " INFO [tag]"), line)
+			assert.Equal(t, addSource, strings.Contains(line, "main_test.go:"), line)
+		}
+	}
+}
+
+func TestHandlerLevelVar(t *testing.T) {
+	var buf bytes.Buffer
+	var level slog.LevelVar
+	level.Set(slog.LevelWarn)
+
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithLevel(&level))
+	logger := slog.New(handler)
+	tagged := logger.With(Tag("db")).WithGroup("g")
+
+	logger.Info("not logged")
+	tagged.Info("not logged")
+	assert.Empty(t, buf.String())
+
+	level.Set(slog.LevelDebug)
+	logger.Debug("debug message")
+	tagged.Debug("tagged message")
+	assert.Equal(t, "DEBUG\tdebug message\nDEBUG\t[db]\ttagged message\n", buf.String())
+
+	buf.Reset()
+	level.Set(slog.LevelError)
+	logger.Warn("not logged")
+	tagged.Warn("not logged")
+	assert.Empty(t, buf.String())
+
+	// NewHandler must not snapshot the level either.
+	buf.Reset()
+	handler = NewHandler(&buf, &Options{Level: &level, TimeFormat: ""})
+	level.Set(slog.LevelInfo)
+	slog.New(handler).Info("info message")
+	assert.Equal(t, "INFO\tinfo message\n", buf.String())
+}
+
+func TestHandlerVocabulary(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""))
+	slog.New(handler).Info("msg", "t", true, "f", false, "n", nil)
+	assert.Equal(t, "INFO\tmsg t=true f=false n=<nil>\n", buf.String())
+
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithVocabulary(Vocabulary{
+		TrueText:  "Y",
+		FalseText: "N",
+		NilText:   "NULL",
+	}))
+	slog.New(handler).Info("msg", "t", true, "f", false, "n", nil, slog.Group("g", "b", true))
+	assert.Equal(t, "INFO\tmsg t=Y f=N n=NULL g.b=Y\n", buf.String())
+
+	// Empty fields keep the default words.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithVocabulary(Vocabulary{NilText: "-"}))
+	slog.New(handler).Info("msg", "t", true, "f", false, "n", nil)
+	assert.Equal(t, "INFO\tmsg t=true f=false n=-\n", buf.String())
+}
+
+type point struct{ X, Y int }
+
+func TestHandlerTypeFormatters(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""),
+		WithTypeFormatters(
+			func(v any) (slog.Value, bool) {
+				p, ok := v.(point)
+				if !ok {
+					return slog.Value{}, false
+				}
+				return slog.GroupValue(slog.Int("x", p.X), slog.Int("y", p.Y)), true
+			},
+			func(v any) (slog.Value, bool) {
+				return slog.StringValue("unreachable"), true
+			},
+		),
+	)
+	slog.New(handler).Info("msg", "p", point{1, 2}, "s", "text", "other", []int{1})
+	assert.Equal(t, "INFO\tmsg p.x=1 p.y=2 s=\"text\" other=\"unreachable\"\n", buf.String())
+}
+
+func TestHandlerAddChecksum(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(RFC3339Milli), WithAddChecksum(true))
+	logger := slog.New(handler)
+
+	logger.Info("first message", "key", "value")
+	logger.With(Tag("tag")).Error("second message", "n", 42)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	for _, line := range lines {
+		i := strings.LastIndex(line, " checksum=")
+		if !assert.Greater(t, i, 0, line) {
+			continue
+		}
+		content, sum := line[:i], line[i+len(" checksum="):]
+		assert.Equal(t, fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(content))), sum)
+	}
+
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddChecksum(true))
+	slog.New(handler).Info("msg")
+	assert.Equal(t, fmt.Sprintf("INFO\tmsg checksum=%08x\n", crc32.ChecksumIEEE([]byte("INFO\tmsg"))), buf.String())
+}
+
+func TestHandlerWithAttrsMerged(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandler(&buf, &Options{
+		TimeFormat: "",
+	})
+
+	base := handler.WithAttrs([]slog.Attr{slog.Int("a", 1)})
+	h1 := base.WithAttrs([]slog.Attr{slog.Int("b", 2)}).WithAttrs([]slog.Attr{Tag("t1")})
+	h2 := base.WithAttrs([]slog.Attr{slog.Int("c", 3)}).
+		WithGroup("g").
+		WithAttrs([]slog.Attr{slog.Int("d", 4)}).
+		WithAttrs([]slog.Attr{slog.Int("e", 5)})
+
+	// Consecutive attribute layers are merged, group boundaries are kept.
+	assert.Len(t, h1.(*Handler).goas, 1)
+	assert.Len(t, h2.(*Handler).goas, 3)
+
+	slog.New(base).Info("msg", "x", 0)
+	slog.New(h1).Info("msg", "x", 0)
+	slog.New(h2).Info("msg", "x", 0)
+	expected := "INFO\tmsg a=1 x=0\n" +
+		"INFO\t[t1]\tmsg a=1 b=2 x=0\n" +
+		"INFO\tmsg a=1 c=3 g.d=4 g.e=5 g.x=0\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestHandlerMultipleTags(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandler(&buf, &Options{
+		TimeFormat: "",
+	})
+	slog.New(handler).With(Tag("api")).With("k", "v").With(Tag("v2")).Info("msg")
+	assert.Equal(t, "INFO\t[api]\t[v2]\tmsg k=\"v\"\n", buf.String())
+}
+
+func BenchmarkHandlerChainedWithAttrs(b *testing.B) {
+	b.Run("Chained", func(b *testing.B) {
+		var buf bytes.Buffer
+		logger := slog.New(NewHandler(&buf, &Options{TimeFormat: ""}))
+		for i := 0; i < 10; i++ {
+			logger = logger.With(fmt.Sprintf("key%d", i), i)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			logger.Info("benchmark message")
+		}
+	})
+
+	b.Run("Single", func(b *testing.B) {
+		var buf bytes.Buffer
+		args := make([]any, 0, 20)
+		for i := 0; i < 10; i++ {
+			args = append(args, fmt.Sprintf("key%d", i), i)
+		}
+		logger := slog.New(NewHandler(&buf, &Options{TimeFormat: ""})).With(args...)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			logger.Info("benchmark message")
+		}
+	})
+}
+
+func TestHandlerFormatRecord(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithAddSource(true), WithAddChecksum(true))
+	h := handler.WithAttrs([]slog.Attr{Tag("tag"), slog.String("a", "b")}).WithGroup("g").(*Handler)
+
+	var pcs [1]uintptr
+	runtime.Callers(1, pcs[:])
+	r := slog.NewRecord(time.Now(), slog.LevelWarn, "test message", pcs[0])
+	r.AddAttrs(slog.Int("n", 1), slog.Duration("d", time.Second))
+
+	formatted, err := h.FormatRecord(context.Background(), r)
+	assert.NoError(t, err)
+	assert.Empty(t, buf.String())
+
+	assert.NoError(t, h.Handle(context.Background(), r))
+	assert.Equal(t, buf.String(), string(formatted))
+}
+
+func TestHandlerLevelFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   LevelFormat
+		level    slog.Level
+		expected string
+	}{
+		{"Text", LevelText, slog.LevelError, "ERROR\tmsg\n"},
+		{"Numeric", LevelNumeric, slog.LevelError, "8\tmsg\n"},
+		{"Numeric info", LevelNumeric, slog.LevelInfo, "0\tmsg\n"},
+		{"Numeric debug", LevelNumeric, slog.LevelDebug, "-4\tmsg\n"},
+		{"Numeric custom", LevelNumeric, slog.LevelInfo + 2, "2\tmsg\n"},
+		{"NumericText", LevelNumericText, slog.LevelError, "8:ERROR\tmsg\n"},
+		{"NumericText warn", LevelNumericText, slog.LevelWarn, "4:WARN\tmsg\n"},
+		{"NumericText custom", LevelNumericText, slog.LevelInfo + 2, "2:INFO+2\tmsg\n"},
+		{"Syslog error", LevelSyslog, slog.LevelError + 4, "3\tmsg\n"},
+		{"Syslog warn", LevelSyslog, slog.LevelWarn, "4\tmsg\n"},
+		{"Syslog notice", LevelSyslog, slog.LevelInfo + 2, "5\tmsg\n"},
+		{"Syslog info", LevelSyslog, slog.LevelInfo, "6\tmsg\n"},
+		{"Syslog debug", LevelSyslog, slog.LevelDebug, "7\tmsg\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithLevel(slog.LevelDebug), WithLevelFormat(tt.format))
+			slog.New(handler).Log(context.Background(), tt.level, "msg")
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func TestHandlerLargeRecord(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandler(&buf, &Options{
+		TimeFormat: "",
+	})
+
+	large := strings.Repeat("x", 2*maxBufferSize)
+	slog.New(handler).Info("large message", "value", large, "after", 1)
+
+	expected := "INFO\tlarge message value=\"" + large + "\" after=1\n"
+	assert.Equal(t, expected, buf.String())
+
+	// The following records must not be affected by the oversized buffer.
+	buf.Reset()
+	slog.New(handler).Info("small message", "key", "value")
+	assert.Equal(t, "INFO\tsmall message key=\"value\"\n", buf.String())
+}
+
+func TestHandlerKeyValueSeparator(t *testing.T) {
+	tm := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		separator string
+		expected  string
+	}{
+		{"", "INFO\tmsg s=\"v\" i=-1 u=2 f=1.5 b=true d=1s t=2024-06-01T12:30:00Z g.n=<nil>\n"},
+		{":", "INFO\tmsg s:\"v\" i:-1 u:2 f:1.5 b:true d:1s t:2024-06-01T12:30:00Z g.n:<nil>\n"},
+		{": ", "INFO\tmsg s: \"v\" i: -1 u: 2 f: 1.5 b: true d: 1s t: 2024-06-01T12:30:00Z g.n: <nil>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.separator, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := NewHandlerWithOptions(&buf,
+				WithTimeFormat(""),
+				WithTimeAttributeFormat(time.RFC3339),
+				WithKeyValueSeparator(tt.separator),
+			)
+			slog.New(handler).Info("msg",
+				slog.String("s", "v"),
+				slog.Int("i", -1),
+				slog.Uint64("u", 2),
+				slog.Float64("f", 1.5),
+				slog.Bool("b", true),
+				slog.Duration("d", time.Second),
+				slog.Time("t", tm),
+				slog.Group("g", slog.Any("n", nil)),
+			)
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func TestHandlerSourceMinLevel(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf,
+		WithTimeFormat(""),
+		WithAddSource(true),
+		WithSourceMinLevel(slog.LevelWarn),
+	)
+	logger := slog.New(handler)
+
+	logger.Info("info message")
+	assert.Equal(t, "INFO\tinfo message\n", buf.String())
+
+	buf.Reset()
+	logger.Error("error message")
+	assert.Regexp(t, `^ERROR\t\S+main_test\.go:\d+\terror message\n$`, buf.String())
+
+	// Without SourceMinLevel the source is included for all levels.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddSource(true))
+	slog.New(handler).Info("info message")
+	assert.Regexp(t, `^INFO\t\S+main_test\.go:\d+\tinfo message\n$`, buf.String())
+}
+
+func logFromNestedCalls(logger *slog.Logger) {
+	func() {
+		logger.Info("nested")
+	}()
+}
+
+func TestHandlerSourceFrames(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddSource(true), WithSourceFrames(3))
+	logFromNestedCalls(slog.New(handler))
+
+	fields := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\t")
+	assert.Len(t, fields, 3)
+	frames := strings.Split(fields[1], ";")
+	assert.Len(t, frames, 3)
+	for _, frame := range frames {
+		assert.Regexp(t, `main_test\.go:\d+$`, frame)
+	}
+	assert.NotEqual(t, frames[0], frames[1])
+
+	// The chain is shorter if the stack is not as deep.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddSource(true), WithSourceFrames(1000))
+	slog.New(handler).Info("msg")
+	fields = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\t")
+	frames = strings.Split(fields[1], ";")
+	assert.Greater(t, len(frames), 1)
+	assert.LessOrEqual(t, len(frames), MaxSourceFrames)
+	assert.Regexp(t, `main_test\.go:\d+$`, frames[0])
+}
+
+func TestHandlerSourceFramesNotOnStack(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddSource(true), WithSourceFrames(3))
+
+	// The record is handled after the function that created it has returned,
+	// so only the location of the log statement is known.
+	r := func() slog.Record {
+		var pcs [1]uintptr
+		runtime.Callers(1, pcs[:])
+		return slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", pcs[0])
+	}()
+	assert.NoError(t, handler.Handle(context.Background(), r))
+	assert.Regexp(t, `^INFO\t[^;\t]+main_test\.go:\d+\tmsg\n$`, buf.String())
+}
+
+func TestHandlerExpandErrorChain(t *testing.T) {
+	inner := errors.New("connection refused")
+	middle := fmt.Errorf("dial db: %w", inner)
+	outer := fmt.Errorf("load user: %w", middle)
+
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""))
+	slog.New(handler).Error("failed", "err", outer)
+	assert.Equal(t, "ERROR\tfailed err=load user: dial db: connection refused\n", buf.String())
+
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithExpandErrorChain(true))
+	logger := slog.New(handler)
+	logger.Error("failed", "err", outer)
+	assert.Equal(t, "ERROR\tfailed err=\"load user: dial db: connection refused\" err.cause=\"connection refused\"\n", buf.String())
+
+	buf.Reset()
+	logger.WithGroup("g").Error("failed", "err", inner)
+	assert.Equal(t, "ERROR\tfailed g.err=\"connection refused\"\n", buf.String())
+}
+
+func TestHandlerTimeFormatOverride(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(time.DateOnly), WithTimeInUTC(true))
+	tm := time.Date(2024, 6, 1, 12, 30, 45, 123456000, time.UTC)
+
+	r := slog.NewRecord(tm, slog.LevelInfo, "precise", 0)
+	r.AddAttrs(slog.Int("a", 1), TimeFormatOverride(RFC3339Micro), slog.Int("b", 2))
+	assert.NoError(t, handler.Handle(context.Background(), r))
+
+	r = slog.NewRecord(tm, slog.LevelInfo, "default", 0)
+	r.AddAttrs(slog.Int("a", 1))
+	assert.NoError(t, handler.Handle(context.Background(), r))
+
+	expected := "2024-06-01T12:30:45.123456Z\tINFO\tprecise a=1 b=2\n" +
+		"2024-06-01\tINFO\tdefault a=1\n"
+	assert.Equal(t, expected, buf.String())
+
+	// The override can be set with With() and overridden by the record.
+	buf.Reset()
+	h := handler.WithAttrs([]slog.Attr{TimeFormatOverride(time.TimeOnly)})
+	r = slog.NewRecord(tm, slog.LevelInfo, "with", 0)
+	assert.NoError(t, h.Handle(context.Background(), r))
+	r = slog.NewRecord(tm, slog.LevelInfo, "record", 0)
+	r.AddAttrs(TimeFormatOverride(time.Kitchen))
+	assert.NoError(t, h.Handle(context.Background(), r))
+	assert.Equal(t, "12:30:45\tINFO\twith\n12:30PM\tINFO\trecord\n", buf.String())
+}
+
+func TestHandlerFoldRepeats(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(time.TimeOnly), WithTimeInUTC(true), WithFoldRepeats(true))
+	tm := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	handle := func(h slog.Handler, msg string, args ...any) {
+		tm = tm.Add(time.Second)
+		r := slog.NewRecord(tm, slog.LevelWarn, msg, 0)
+		r.Add(args...)
+		assert.NoError(t, h.Handle(context.Background(), r))
+	}
+
+	handle(handler, "disk full", "disk", "sda")
+	handle(handler, "disk full", "disk", "sda")
+	handle(handler, "disk full", "disk", "sda")
+	handle(handler, "disk full", "disk", "sda")
+	handle(handler, "disk full", "disk", "sdb")
+	handle(handler, "disk full", "disk", "sdb")
+	expected := "10:00:01\tWARN\tdisk full disk=\"sda\"\n" +
+		"10:00:04\tWARN\tlast message repeated suppressed_count=3 window=3s\n" +
+		"10:00:05\tWARN\tdisk full disk=\"sdb\"\n"
+	assert.Equal(t, expected, buf.String())
+
+	// Close writes the pending summary.
+	buf.Reset()
+	assert.NoError(t, handler.Close())
+	assert.Equal(t, "10:00:06\tWARN\tlast message repeated suppressed_count=1 window=1s\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, handler.Close())
+	assert.Empty(t, buf.String())
+
+	// Records from derived handlers differ by their attributes.
+	handle(handler, "disk full")
+	handle(handler.WithAttrs([]slog.Attr{Tag("db")}), "disk full")
+	handle(handler.WithAttrs([]slog.Attr{Tag("db")}), "disk full")
+	assert.NoError(t, handler.Close())
+	expected = "10:00:07\tWARN\tdisk full\n" +
+		"10:00:08\tWARN\t[db]\tdisk full\n" +
+		"10:00:09\tWARN\tlast message repeated suppressed_count=1 window=1s\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestHandlerTabularAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithTabularAttrs(true))
+	logger := slog.New(handler)
+
+	logger.Info("request", "method", "DELETE", "status", 200, "path", "/a")
+	logger.Info("request", "method", "GET", "status", 4, "path", "/abc")
+	logger.Info("request", "method", "PUT", "status", 404, "path", "/b")
+
+	// Widths are learned from the previous records, so the first record is not padded.
+	expected := "INFO\trequest method=\"DELETE\" status=200 path=\"/a\"\n" +
+		"INFO\trequest method=\"GET\"    status=4   path=\"/abc\"\n" +
+		"INFO\trequest method=\"PUT\"    status=404 path=\"/b\"\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestHandlerTabularAttrsTrailingSpaces(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithTabularAttrs(true)))
+
+	// Only the padding of the last value is trimmed, not the spaces of the value.
+	logger.Info("msg", "err", errors.New("a long message"))
+	logger.Info("msg", "err", errors.New("short  "))
+	logger.Info("msg", "err", errors.New("a long message"), "n", 1)
+	expected := "INFO\tmsg err=a long message\n" +
+		"INFO\tmsg err=short  \n" +
+		"INFO\tmsg err=a long message n=1\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestHandlerTabularAttrsWindow(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithTabularAttrs(true))
+	logger := slog.New(handler)
+
+	logger.Info("msg", "k", "a long value", "n", 1)
+	for i := 0; i < tabularWindow+1; i++ {
+		logger.Info("msg", "k", "short", "n", 1)
+	}
+
+	// The wide value is out of the window, so the column shrinks.
+	buf.Reset()
+	logger.Info("msg", "k", "short", "n", 1)
+	assert.Equal(t, "INFO\tmsg k=\"short\" n=1\n", buf.String())
+}
+
+func TestHandlerLevelLabels(t *testing.T) {
+	const (
+		LevelTrace  = slog.LevelDebug - 4
+		LevelNotice = slog.LevelInfo + 2
+	)
+	labels := map[slog.Level]string{
+		LevelTrace:     "TRACE",
+		LevelNotice:    "NOTICE",
+		slog.LevelWarn: "WARNING",
+	}
+
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithLevel(LevelTrace), WithLevelLabels(labels))
+	logger := slog.New(handler)
+	ctx := context.Background()
+
+	logger.Log(ctx, LevelTrace, "trace")
+	logger.Log(ctx, LevelNotice, "notice")
+	logger.Warn("warn")
+	logger.Error("error")
+	assert.Equal(t, "TRACE\ttrace\nNOTICE\tnotice\nWARNING\twarn\nERROR\terror\n", buf.String())
+
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithLevelLabels(labels), WithLevelFormat(LevelNumericText))
+	slog.New(handler).Log(ctx, LevelNotice, "notice")
+	assert.Equal(t, "2:NOTICE\tnotice\n", buf.String())
+
+	// Without labels, the slog names are used.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""))
+	slog.New(handler).Log(ctx, LevelNotice, "notice")
+	assert.Equal(t, "INFO+2\tnotice\n", buf.String())
+}
+
+func TestHandlerFramingMode(t *testing.T) {
+	readFrames := func(t *testing.T, data []byte) []string {
+		var frames []string
+		for len(data) > 0 {
+			if !assert.GreaterOrEqual(t, len(data), 4) {
+				break
+			}
+			n := int(binary.BigEndian.Uint32(data))
+			data = data[4:]
+			if !assert.GreaterOrEqual(t, len(data), n) {
+				break
+			}
+			frames = append(frames, string(data[:n]))
+			data = data[n:]
+		}
+		return frames
+	}
+
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithFramingMode(FramingLengthPrefix))
+	logger := slog.New(handler)
+	logger.Info("first", "key", "value")
+	logger.Warn("second\nwith newline")
+	assert.Equal(t, []string{"INFO\tfirst key=\"value\"", "WARN\tsecond\nwith newline"}, readFrames(t, buf.Bytes()))
+
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithFramingMode(FramingLengthPrefixNewline), WithAddChecksum(true))
+	logger = slog.New(handler)
+	logger.Info("first")
+	logger.Info("second")
+	frames := readFrames(t, buf.Bytes())
+	assert.Len(t, frames, 2)
+	for i, frame := range frames {
+		line := strings.TrimSuffix(frame, "\n")
+		assert.NotEqual(t, line, frame)
+		content, sum, _ := strings.Cut(line, " checksum=")
+		assert.Equal(t, []string{"INFO\tfirst", "INFO\tsecond"}[i], content)
+		assert.Equal(t, fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(content))), sum)
+	}
+}
+
+func TestHandlerPriorityKeys(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithPriorityKeys("request_id", "error", "http.status"))
+	logger := slog.New(handler).With("user", "admin", "request_id", "r1")
+
+	logger.Info("msg", "a", 1, "error", "failed", "b", 2)
+	logger.Info("msg", "a", 1, slog.Group("http", "method", "GET", "status", 200), "b", 2)
+	logger.Info("msg", "a", 1, "b", 2)
+	slog.New(handler).Info("msg", "a", 1, "b", 2)
+	logger.WithGroup("g").Info("msg", "error", "not a priority key")
+	expected := "INFO\tmsg request_id=\"r1\" error=\"failed\" user=\"admin\" a=1 b=2\n" +
+		"INFO\tmsg request_id=\"r1\" http.status=200 user=\"admin\" a=1 http.method=\"GET\" b=2\n" +
+		"INFO\tmsg request_id=\"r1\" user=\"admin\" a=1 b=2\n" +
+		"INFO\tmsg a=1 b=2\n" +
+		"INFO\tmsg request_id=\"r1\" user=\"admin\" g.error=\"not a priority key\"\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestHandlerTimeAttributeOmitZone(t *testing.T) {
+	zone := time.FixedZone("AEST", 10*60*60)
+	tm := time.Date(2024, 6, 1, 12, 30, 45, 123000000, zone)
+
+	tests := []struct {
+		layout     string
+		inUTC      bool
+		omitZone   bool
+		expected   string
+		normalized string
+	}{
+		{time.RFC3339, true, false, "2024-06-01T02:30:45Z", time.RFC3339},
+		{time.RFC3339, true, true, "2024-06-01T02:30:45", "2006-01-02T15:04:05"},
+		{time.RFC3339, false, false, "2024-06-01T12:30:45+10:00", time.RFC3339},
+		{time.RFC3339, false, true, "2024-06-01T12:30:45", "2006-01-02T15:04:05"},
+		{RFC3339Milli, true, true, "2024-06-01T02:30:45.123", "2006-01-02T15:04:05.000"},
+		{RFC3339Milli, false, true, "2024-06-01T12:30:45.123", "2006-01-02T15:04:05.000"},
+		{time.RFC1123, true, true, "Sat, 01 Jun 2024 02:30:45", "Mon, 02 Jan 2006 15:04:05"},
+		{time.RFC1123Z, false, true, "Sat, 01 Jun 2024 12:30:45", "Mon, 02 Jan 2006 15:04:05"},
+		{time.UnixDate, false, true, "Sat Jun  1 12:30:45 2024", "Mon Jan _2 15:04:05 2006"},
+		{time.DateTime, true, true, "2024-06-01 02:30:45", time.DateTime},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/utc=%v/omit=%v", tt.layout, tt.inUTC, tt.omitZone), func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := NewHandlerWithOptions(&buf,
+				WithTimeFormat(""),
+				WithTimeAttributeFormat(tt.layout),
+				WithTimeAttributeInUTC(tt.inUTC),
+				WithTimeAttributeOmitZone(tt.omitZone),
+			)
+			assert.Equal(t, tt.normalized, handler.opts.TimeAttributeFormat)
+			slog.New(handler).Info("msg", "t", tm)
+			assert.Equal(t, "INFO\tmsg t="+tt.expected+"\n", buf.String())
+		})
+	}
+}
+
+func TestHandlerDerivedWithOptions(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""))
+	base := handler.WithAttrs([]slog.Attr{Tag("app"), slog.String("k", "v")}).(*Handler)
+
+	// The subtree logs the source and debug records.
+	sub := base.WithOptions(WithAddSource(true), WithLevel(slog.LevelDebug))
+
+	slog.New(base).Debug("not logged")
+	slog.New(base).Info("base message")
+	slog.New(sub).Debug("sub message")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, "INFO\t[app]\tbase message k=\"v\"", lines[0])
+	assert.Regexp(t, `^DEBUG\t\[app\]\t\S+main_test\.go:\d+\tsub message k="v"$`, lines[1])
+
+	// The original handler is not modified.
+	assert.False(t, base.opts.AddSource)
+	assert.Equal(t, slog.LevelInfo, base.opts.Level.Level())
+
+	// Options derived from the others are recomputed.
+	buf.Reset()
+	withConst := base.WithOptions(WithConstantAttrs(slog.Int("n", 1)), WithKeyValueSeparator(": "))
+	slog.New(withConst).Info("msg")
+	assert.Equal(t, "INFO\t[app]\tmsg n: 1 k: \"v\"\n", buf.String())
+}
+
+func TestHandlerAddRecordID(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithAddRecordID(true))
+	logger := slog.New(handler)
+
+	recordID := func(line string) string {
+		_, id, ok := strings.Cut(line, " record_id=")
+		assert.True(t, ok, line)
+		return id
+	}
+	log := func(f func()) string {
+		buf.Reset()
+		f()
+		return strings.TrimSuffix(buf.String(), "\n")
+	}
+
+	first := log(func() { logger.Info("payment", "id", 42, "amount", 1.5) })
+	retry := log(func() {
+		time.Sleep(2 * time.Millisecond)
+		logger.Info("payment", "id", 42, "amount", 1.5)
+	})
+	reordered := log(func() { logger.Info("payment", "amount", 1.5, "id", 42) })
+	assert.Len(t, recordID(first), 16)
+	assert.Equal(t, recordID(first), recordID(retry))
+	assert.Equal(t, recordID(first), recordID(reordered))
+	assert.Contains(t, first, "\tpayment amount=1.5 id=42 record_id=")
+
+	assert.NotEqual(t, recordID(first), recordID(log(func() { logger.Info("payment", "id", 43, "amount", 1.5) })))
+	assert.NotEqual(t, recordID(first), recordID(log(func() { logger.Warn("payment", "id", 42, "amount", 1.5) })))
+	assert.NotEqual(t, recordID(first), recordID(log(func() { logger.Info("refund", "id", 42, "amount", 1.5) })))
+	assert.NotEqual(t, recordID(first), recordID(log(func() { logger.Info("payment", "id", 42) })))
+
+	// The record ID precedes the checksum.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddRecordID(true), WithAddChecksum(true))
+	slog.New(handler).WithGroup("g").Info("msg", "b", 2, "a", 1)
+	line := strings.TrimSuffix(buf.String(), "\n")
+	content, sum, _ := strings.Cut(line, " checksum=")
+	assert.Regexp(t, `^INFO\tmsg g\.a=1 g\.b=2 record_id=[0-9a-f]{16}$`, content)
+	assert.Equal(t, fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(content))), sum)
+}
+
+func TestHandlerSourceRoot(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	root := filepath.Dir(filepath.Dir(file))
+
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddSource(true), WithSourceRoot(root))
+	slog.New(handler).Info("message")
+	rel := filepath.Base(filepath.Dir(file)) + "/main_test.go"
+	assert.Regexp(t, `^INFO\t`+regexp.QuoteMeta(rel)+`:\d+\tmessage\n$`, buf.String())
+
+	// Paths outside of the root are written in full.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddSource(true), WithSourceRoot(filepath.Join(root, "other")))
+	slog.New(handler).Info("message")
+	assert.Regexp(t, `^INFO\t`+regexp.QuoteMeta(file)+`:\d+\tmessage\n$`, buf.String())
+}
+
+func TestHandlerFloatSpecials(t *testing.T) {
+	tests := []struct {
+		mode     FloatSpecials
+		expected string
+	}{
+		{FloatSpecialsLiteral, "INFO\tmsg nan=NaN pos=+Inf neg=-Inf num=1.5\n"},
+		{FloatSpecialsQuoted, "INFO\tmsg nan=\"NaN\" pos=\"+Inf\" neg=\"-Inf\" num=1.5\n"},
+		{FloatSpecialsNull, "INFO\tmsg nan=null pos=null neg=null num=1.5\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithFloatSpecials(tt.mode))
+		slog.New(handler).Info("msg", "nan", math.NaN(), "pos", math.Inf(1), "neg", math.Inf(-1), "num", 1.5)
+		assert.Equal(t, tt.expected, buf.String())
+	}
+}
+
+func TestHandlerLevelFunc(t *testing.T) {
+	// Debug logging during business hours only.
+	businessHours := func(t time.Time) slog.Level {
+		if t.Hour() >= 9 && t.Hour() < 17 {
+			return slog.LevelDebug
+		}
+		return slog.LevelWarn
+	}
+
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(time.TimeOnly), WithLevelFunc(businessHours))
+	ctx := context.Background()
+	for _, hour := range []int{8, 9, 16, 17} {
+		for _, level := range []slog.Level{slog.LevelDebug, slog.LevelWarn} {
+			r := slog.NewRecord(time.Date(2024, 6, 1, hour, 0, 0, 0, time.Local), level, "msg", 0)
+			assert.NoError(t, handler.Handle(ctx, r))
+		}
+	}
+	assert.Equal(t, "08:00:00\tWARN\tmsg\n"+
+		"09:00:00\tDEBUG\tmsg\n09:00:00\tWARN\tmsg\n"+
+		"16:00:00\tDEBUG\tmsg\n16:00:00\tWARN\tmsg\n"+
+		"17:00:00\tWARN\tmsg\n", buf.String())
+
+	// Enabled uses the current time.
+	handler = NewHandlerWithOptions(&buf, WithLevelFunc(func(time.Time) slog.Level { return slog.LevelError }))
+	assert.False(t, handler.Enabled(ctx, slog.LevelWarn))
+	assert.True(t, handler.Enabled(ctx, slog.LevelError))
+
+	// The context level takes precedence.
+	buf.Reset()
+	ctx = ContextWithLevel(ctx, slog.LevelDebug)
+	assert.True(t, handler.Enabled(ctx, slog.LevelDebug))
+	slog.New(handler).DebugContext(ctx, "debug")
+	assert.Contains(t, buf.String(), "DEBUG\tdebug\n")
+}
+
+func TestHandlerTrimMessage(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithTrimMessage(true)))
+	logger.Info("  leading and trailing \t")
+	logger.Info("\nfirst line\nsecond line\n\n", "k", 1)
+	logger.Info(" \n ")
+	assert.Equal(t, "INFO\tleading and trailing\nINFO\tfirst line\nsecond line k=1\nINFO\t\n", buf.String())
+
+	// The message is kept as is by default.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat("")))
+	logger.Info(" message ")
+	assert.Equal(t, "INFO\t message \n", buf.String())
+}
+
+type userValue struct{ id int }
+
+func (u userValue) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("id", u.id), slog.String("role", "admin"))
+}
+
+func TestHandlerAttrMap(t *testing.T) {
+	handler := NewHandlerWithOptions(nil,
+		WithTimeFormat(""),
+		WithConstantAttrs(slog.String("service", "api")),
+		WithExpandErrorChain(true),
+	)
+	var h slog.Handler = handler.WithAttrs([]slog.Attr{Tag("db"), slog.Int("conn", 1)})
+	h = h.WithGroup("req").WithAttrs([]slog.Attr{slog.String("method", "GET")})
+
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0)
+	r.AddAttrs(
+		slog.Any("user", userValue{7}),
+		slog.Group("http", slog.Int("status", 200), slog.Group("empty")),
+		slog.Any("err", fmt.Errorf("query: %w", errors.New("timeout"))),
+	)
+
+	m := h.(*Handler).AttrMap(r)
+	assert.Equal(t, map[string]any{
+		"service":         "api",
+		"conn":            int64(1),
+		"req.method":      "GET",
+		"req.user.id":     int64(7),
+		"req.user.role":   "admin",
+		"req.http.status": int64(200),
+		"req.err":         "query: timeout",
+		"req.err.cause":   "timeout",
+	}, m)
+
+	// The keys match the keys of the formatted output.
+	line, err := h.(*Handler).FormatRecord(context.Background(), r)
+	assert.NoError(t, err)
+	_, attrs, _ := strings.Cut(strings.TrimSuffix(string(line), "\n"), "\tmsg ")
+	var keys []string
+	for _, field := range strings.Split(attrs, " ") {
+		if key, _, ok := strings.Cut(field, "="); ok {
+			keys = append(keys, key)
+		}
+	}
+	var mapKeys []string
+	for key := range m {
+		mapKeys = append(mapKeys, key)
+	}
+	assert.ElementsMatch(t, mapKeys, keys)
+
+	// The cause key follows the group separator and the key escaping of the output.
+	handler = NewHandlerWithOptions(nil, WithExpandErrorChain(true), WithGroupSeparator("/"), WithEscapeKeys(true))
+	r = slog.NewRecord(time.Now(), slog.LevelInfo, "msg", 0)
+	r.AddAttrs(slog.Group("db", slog.Any("a/b", fmt.Errorf("query: %w", errors.New("timeout")))))
+	assert.Equal(t, map[string]any{
+		`g/db/a\/b`:       "query: timeout",
+		`g/db/a\/b/cause`: "timeout",
+	}, handler.WithGroup("g").(*Handler).AttrMap(r))
+}
+
+// slowWriter blocks each write until it's released.
+type slowWriter struct {
+	bytes.Buffer
+	release chan struct{}
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.Buffer.Write(p)
+}
+
+func TestHandlerWriteTimeout(t *testing.T) {
+	w := &slowWriter{release: make(chan struct{})}
+	handler := NewHandlerWithOptions(w, WithTimeFormat(""), WithWriteTimeout(10*time.Millisecond))
+	ctx := context.Background()
+	record := func(msg string) slog.Record {
+		return slog.NewRecord(time.Now(), slog.LevelInfo, msg, 0)
+	}
+
+	// The writer is stalled: the write times out.
+	start := time.Now()
+	assert.ErrorIs(t, handler.Handle(ctx, record("first")), ErrWriteTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Records are dropped until the timed out write returns.
+	assert.ErrorIs(t, handler.Handle(ctx, record("dropped")), ErrWriteTimeout)
+
+	close(w.release)
+	assert.Eventually(t, func() bool {
+		return handler.Handle(ctx, record("second")) == nil
+	}, time.Second, time.Millisecond)
+	handler.mu.Lock()
+	assert.True(t, strings.HasPrefix(w.String(), "INFO\tfirst\nINFO\tsecond\n"), w.String())
+	handler.mu.Unlock()
+
+	// Fast writes complete within the timeout.
+	var buf bytes.Buffer
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithWriteTimeout(time.Second))
+	assert.NoError(t, handler.Handle(ctx, record("fast")))
+	assert.Equal(t, "INFO\tfast\n", buf.String())
+}
+
+func TestHandlerSafeRunes(t *testing.T) {
+	alnum := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	urlSafe := func(r rune) bool {
+		return alnum(r) || strings.ContainsRune("/:.-_?&=%", r)
+	}
+
+	tests := []struct {
+		name      string
+		safeRunes func(r rune) bool
+		expected  string
+	}{
+		{"default", nil, `url="https://example.com/a?b=1" path="/var/log/app.log" word="ok" empty="" text="a b"`},
+		{"alnum", alnum, `url="https://example.com/a?b=1" path="/var/log/app.log" word=ok empty="" text="a b"`},
+		{"url", urlSafe, `url=https://example.com/a?b=1 path=/var/log/app.log word=ok empty="" text="a b"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithSafeRunes(tt.safeRunes))
+			slog.New(handler).Info("msg",
+				"url", "https://example.com/a?b=1",
+				"path", "/var/log/app.log",
+				"word", "ok",
+				"empty", "",
+				"text", "a b",
+			)
+			assert.Equal(t, "INFO\tmsg "+tt.expected+"\n", buf.String())
+		})
+	}
+
+	// Quotes, backslashes and control characters are always quoted.
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithSafeRunes(func(rune) bool { return true }))
+	slog.New(handler).Info("msg", "q", `a"b`, "b", `a\b`, "nl", "a\nb", "s", "a_b")
+	assert.Equal(t, "INFO\tmsg q=\"a\\\"b\" b=\"a\\\\b\" nl=\"a\\nb\" s=a_b\n", buf.String())
+}
+
+func TestHandlerTagMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{"bracket", nil, "INFO\t[db]\t[pool]\tmsg g.k=1\n"},
+		{"attr", []Option{WithTagMode(TagAttr)}, "INFO\tmsg tag=\"db\" tag=\"pool\" g.k=1\n"},
+		{"both", []Option{WithTagMode(TagBracketAndAttr)}, "INFO\t[db]\t[pool]\tmsg tag=\"db\" tag=\"pool\" g.k=1\n"},
+		{"key", []Option{WithTagMode(TagAttr), WithTagKey("component")}, "INFO\tmsg component=\"db\" component=\"pool\" g.k=1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := NewHandlerWithOptions(&buf, append([]Option{WithTimeFormat("")}, tt.opts...)...)
+			slog.New(handler).With(Tag("db")).WithGroup("g").With(Tag("pool")).Info("msg", "k", 1)
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func TestHandlerTagQuoting(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat("")))
+
+	// Tags with spaces, brackets or control characters are quoted in the brackets.
+	logger.With(Tag("my tag")).Info("msg")
+	logger.With(Tag("a]b")).Info("msg")
+	logger.With(Tag("db")).Info("msg")
+	expected := "INFO\t[\"my tag\"]\tmsg\n" +
+		"INFO\t[\"a]b\"]\tmsg\n" +
+		"INFO\t[db]\tmsg\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestHandlerStrictKeys(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithStrictKeys(true))
+	ctx := context.Background()
+	handle := func(h slog.Handler, attrs ...slog.Attr) error {
+		r := slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)
+		r.AddAttrs(attrs...)
+		return h.Handle(ctx, r)
+	}
+
+	err := handle(handler, slog.Int("", 1))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	assert.ErrorContains(t, err, `empty key with value "1"`)
+
+	err = handle(handler, slog.Group("http", slog.Int("req.status", 200)))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	assert.ErrorContains(t, err, `key "req.status" contains "."`)
+
+	err = handle(handler.WithGroup("a.b"), slog.Int("k", 1))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	err = handle(handler.WithAttrs([]slog.Attr{slog.String("", "x")}))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	assert.Empty(t, buf.String())
+
+	// Inline groups with an empty key and empty attributes are valid.
+	assert.NoError(t, handle(handler.WithAttrs([]slog.Attr{Tag("db")}),
+		slog.Group("", slog.Int("a", 1)), slog.Attr{}, slog.Int("b", 2)))
+	assert.Equal(t, "INFO\t[db]\tmsg a=1 b=2\n", buf.String())
+
+	// Without StrictKeys the record is written as is.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""))
+	assert.NoError(t, handle(handler, slog.Int("", 1), slog.Int("a.b", 2)))
+	assert.Equal(t, "INFO\tmsg =1 a.b=2\n", buf.String())
+}
+
+// countingValuer is a LogValuer that counts the LogValue calls.
+type countingValuer struct{ calls *int }
+
+func (v countingValuer) LogValue() slog.Value {
+	*v.calls++
+	return slog.IntValue(*v.calls)
+}
+
+func TestHandlerStrictKeysResolveOnce(t *testing.T) {
+	var buf bytes.Buffer
+	var calls int
+	v := countingValuer{&calls}
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithStrictKeys(true), WithSortAttrs(SortByKey))
+	logger := slog.New(handler).With("a", v)
+
+	logger.Info("msg", "b", v, slog.Group("g", "c", v))
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "INFO\tmsg a=1 b=2 g.c=3\n", buf.String())
+
+	// The attributes of the Handler are resolved once by WithAttrs, not for every record.
+	buf.Reset()
+	logger.Info("msg")
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "INFO\tmsg a=1\n", buf.String())
+
+	// The malformed keys of the Handler are reported for every record.
+	buf.Reset()
+	h := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithStrictKeys(true))
+	h2 := h.WithAttrs([]slog.Attr{slog.Int("x.y", 1)}).WithGroup("g.h")
+	for range 2 {
+		err := h2.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0))
+		assert.ErrorIs(t, err, ErrInvalidKey)
+		assert.ErrorContains(t, err, `key "x.y"`)
+		assert.ErrorContains(t, err, `group "g.h"`)
+	}
+	assert.NoError(t, h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)))
+
+	// The resolved values are checked, and the empty groups are kept.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithStrictKeys(true), WithEmitEmpty(true)))
+	logger.Info("msg", "v", valuer{slog.GroupValue(slog.Int("a.b", 1))})
+	assert.Empty(t, buf.String())
+	logger.Info("msg", "user", emptyValuer{}, slog.Group("req", "headers", emptyValuer{}, "n", 1))
+	assert.Equal(t, "INFO\tmsg user={} req.headers={} req.n=1\n", buf.String())
+}
+
+func TestHandlerTimeFormatConstants(t *testing.T) {
+	// 12:30:45 at UTC+2 is 10:30:45 UTC.
+	tm := time.Date(2024, 6, 1, 12, 30, 45, 0, time.FixedZone("CEST", 2*60*60))
+	tests := []struct {
+		layout   string
+		utc      bool
+		expected string
+	}{
+		{ISO8601Basic, true, "20240601T103045Z"},
+		{ISO8601Basic, false, "20240601T123045+0200"},
+		{DateOnly, true, "2024-06-01"},
+		{DateOnly, false, "2024-06-01"},
+		{TimeOnly, true, "10:30:45"},
+		{TimeOnly, false, "12:30:45"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		handler := NewHandlerWithOptions(&buf, WithTimeFormat(tt.layout), WithTimeInUTC(tt.utc))
+		assert.NoError(t, handler.Handle(context.Background(), slog.NewRecord(tm, slog.LevelInfo, "msg", 0)))
+		assert.Equal(t, tt.expected+"\tINFO\tmsg\n", buf.String())
+	}
+}
+
+func TestHandlerAttrsBrackets(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAttrsBrackets(true)))
+	logger.Info("none")
+	logger.Info("one", "k1", "v1")
+	logger.With("k1", 1).WithGroup("g").Info("many", "k2", 2, "k3", 3)
+	assert.Equal(t, "INFO\tnone\n"+
+		"INFO\tone {k1=\"v1\"}\n"+
+		"INFO\tmany {k1=1 g.k2=2 g.k3=3}\n", buf.String())
+
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAttrsBrackets(true), WithAttrsDelimiters("[", "]"),
+		WithConstantAttrs(slog.String("app", "api"))))
+	logger.Info("msg", "k", 1)
+	assert.Equal(t, "INFO\tmsg [app=\"api\" k=1]\n", buf.String())
+}
+
+func TestHandlerComponent(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithHeaderSeparator(" "), WithComponentWidth(6))
+	slog.New(handler.WithOptions(WithComponent("db"))).Info("query")
+	slog.New(handler.WithOptions(WithComponent("http"))).With(Tag("req")).Info("request")
+	slog.New(handler.WithOptions(WithComponent("scheduler"))).Info("tick")
+	slog.New(handler).Info("none")
+	assert.Equal(t, "INFO db    \tquery\n"+
+		"INFO http   [req]\trequest\n"+
+		"INFO scheduler\ttick\n"+
+		"INFO\tnone\n", buf.String())
+}
+
+func TestHandlerAddElapsed(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddElapsed(true), WithDurationFormat(DurationSeconds)))
+
+	var last float64
+	for i := 0; i < 3; i++ {
+		buf.Reset()
+		time.Sleep(time.Millisecond)
+		logger.Info("msg")
+		var elapsed float64
+		_, err := fmt.Sscanf(buf.String(), "%g\tINFO\tmsg\n", &elapsed)
+		assert.NoError(t, err, buf.String())
+		assert.Greater(t, elapsed, last)
+		last = elapsed
+	}
+	assert.Less(t, last, 1.0)
+
+	// The elapsed time follows the timestamp and uses the duration format.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(time.DateOnly), WithAddElapsed(true)))
+	logger.Info("msg")
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}\t[0-9.]+[µnm]?s\tINFO\tmsg\n$`, buf.String())
+}
+
+func TestHandlerKeyAliases(t *testing.T) {
+	var buf bytes.Buffer
+	aliases := map[string]string{"userId": "user_id", "reqInfo": "req", "uid": "user_id"}
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithKeyAliases(aliases))
+	logger := slog.New(handler)
+
+	logger.Info("msg", "userId", 1, slog.Group("reqInfo", "userId", 2, "path", "/"))
+	logger.WithGroup("g").With("userId", 3).Info("msg")
+	// Colliding keys are written in their original order.
+	logger.Info("msg", "uid", 4, "user_id", 5, "userId", 6)
+	assert.Equal(t, "INFO\tmsg user_id=1 req.user_id=2 req.path=\"/\"\n"+
+		"INFO\tmsg g.user_id=3\n"+
+		"INFO\tmsg user_id=4 user_id=5 user_id=6\n", buf.String())
+
+	// The aliases also apply to the reordered attributes.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithKeyAliases(aliases), WithPriorityKeys("user_id"))
+	slog.New(handler).Info("msg", "a", 1, "userId", 2)
+	assert.Equal(t, "INFO\tmsg user_id=2 a=1\n", buf.String())
+
+	// The aliases also apply to the groups of the Handler, with and without reordering.
+	for _, opts := range [][]Option{nil, {WithSortAttrs(SortByKey)}} {
+		buf.Reset()
+		opts = append(opts, WithTimeFormat(""), WithKeyAliases(aliases))
+		slog.New(NewHandlerWithOptions(&buf, opts...)).WithGroup("reqInfo").Info("msg", "userId", 1)
+		assert.Equal(t, "INFO\tmsg req.user_id=1\n", buf.String())
+	}
+}
+
+func TestHandlerInferUnits(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithInferUnits(true)))
+	logger.Info("msg", "latency_ms", 42, "size_bytes", uint64(1024), "cpu_pct", 12.5, "count", 3, "name_ms", "x")
+	assert.Equal(t, "INFO\tmsg latency_ms=42ms size_bytes=1024B cpu_pct=12.5% count=3 name_ms=\"x\"\n", buf.String())
+
+	// The longest matching suffix is used.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithInferUnits(true),
+		WithUnitSuffixes(map[string]string{"_s": "s", "_ms": "ms", "_kb": "KiB"})))
+	logger.Info("msg", "wait_s", 1, "wait_ms", 2, "mem_kb", 3, "size_bytes", 4)
+	assert.Equal(t, "INFO\tmsg wait_s=1s wait_ms=2ms mem_kb=3KiB size_bytes=4\n", buf.String())
+
+	// Units are not appended to nil values and the special float values.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithInferUnits(true)))
+	logger.Info("msg", "a_ms", nil, "b_ms", math.NaN(), "c_ms", math.Inf(1))
+	assert.Equal(t, "INFO\tmsg a_ms=<nil> b_ms=NaN c_ms=+Inf\n", buf.String())
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithInferUnits(true), WithFloatSpecials(FloatSpecialsQuoted)))
+	logger.Info("msg", "b_ms", math.NaN(), "c_ms", math.Inf(-1))
+	assert.Equal(t, "INFO\tmsg b_ms=\"NaN\" c_ms=\"-Inf\"\n", buf.String())
+
+	// Units are not inferred by default.
+	buf.Reset()
+	slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""))).Info("msg", "latency_ms", 42)
+	assert.Equal(t, "INFO\tmsg latency_ms=42\n", buf.String())
+
+	// The defaults are copied when the Handler is created.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithInferUnits(true)))
+	DefaultUnitSuffixes["_min"] = "min"
+	defer delete(DefaultUnitSuffixes, "_min")
+	logger.Info("msg", "wait_min", 5)
+	assert.Equal(t, "INFO\tmsg wait_min=5\n", buf.String())
+}
+
+func TestAppendQuoted(t *testing.T) {
+	for _, s := range []string{
+		"", "value", "with space", "with \"quotes\"", `back\slash`, "multi\nline", "tab\t",
+		"\x00\x7f", "~!@#$%^&*()", "ünïcödé", "日本語", "\u2028", "\xff\xfe",
+	} {
+		assert.Equal(t, strconv.Quote(s), string(appendQuoted(nil, s)), s)
+	}
+}
+
+func BenchmarkHandlerStringAttrs(b *testing.B) {
+	ctx := context.Background()
+	for _, bb := range []struct {
+		name  string
+		value string
+	}{
+		{"Plain", "value"},
+		{"Escaped", "value\n"},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			r := slog.NewRecord(time.Time{}, slog.LevelInfo, "benchmark message", 0)
+			r.AddAttrs(
+				slog.String("key1", bb.value),
+				slog.String("key2", bb.value),
+				slog.String("key3", bb.value),
+				slog.String("key4", bb.value),
+				slog.String("key5", bb.value),
+			)
+			var buf bytes.Buffer
+			handler := NewHandlerWithOptions(&buf, WithTimeFormat(""))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				_ = handler.Handle(ctx, r)
+			}
+		})
+	}
+}
+
+func TestHandlerDoesNotModifyRecord(t *testing.T) {
+	// More attributes than a record stores inline, so a modification would touch the shared backing array.
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)
+	for i := 0; i < 7; i++ {
+		r.AddAttrs(slog.Int(fmt.Sprintf("k%d", i), i))
+	}
+	const attrs = " k0=0 k1=1 k2=2 k3=3 k4=4 k5=5 k6=6"
+
+	var buf1, buf2 bytes.Buffer
+	h1 := NewHandlerWithOptions(&buf1, WithTimeFormat(""), WithConstantAttrs(slog.String("first", "1")),
+		WithTagMode(TagAttr), WithPriorityKeys("k6"))
+	h2 := NewHandlerWithOptions(&buf2, WithTimeFormat(""), WithAddRecordID(true))
+	var handler1 slog.Handler = h1.WithAttrs([]slog.Attr{Tag("db")})
+	var handler2 slog.Handler = h2.WithAttrs([]slog.Attr{slog.String("second", "2")})
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		buf1.Reset()
+		buf2.Reset()
+		assert.NoError(t, handler1.Handle(ctx, r))
+		assert.NoError(t, handler2.Handle(ctx, r))
+		assert.Equal(t, "INFO\tmsg first=\"1\" tag=\"db\" k6=6 k0=0 k1=1 k2=2 k3=3 k4=4 k5=5\n", buf1.String())
+		assert.Regexp(t, `^INFO\tmsg k0=0 k1=1 k2=2 k3=3 k4=4 k5=5 k6=6 second="2" record_id=[0-9a-f]{16}\n$`, buf2.String())
+	}
+
+	assert.Equal(t, 7, r.NumAttrs())
+	var got string
+	r.Attrs(func(a slog.Attr) bool {
+		got += " " + a.String()
+		return true
+	})
+	assert.Equal(t, attrs, got)
+}
+
+// emptyValuer is a LogValuer that resolves to an empty group.
+type emptyValuer struct{}
+
+func (emptyValuer) LogValue() slog.Value {
+	return slog.GroupValue()
+}
+
+func TestHandlerEmitEmpty(t *testing.T) {
+	// slog drops empty groups created with slog.Group, but not the ones resolved from LogValuers
+	// or passed to WithAttrs.
+	log := func(opts ...Option) string {
+		var buf bytes.Buffer
+		handler := NewHandlerWithOptions(&buf, append([]Option{WithTimeFormat("")}, opts...)...)
+		slog.New(handler).
+			With(slog.Attr{Key: "tags", Value: slog.GroupValue()}, slog.Attr{Value: slog.GroupValue()}).
+			LogAttrs(context.Background(), slog.LevelInfo, "msg",
+				slog.String("name", ""),
+				slog.Any("user", emptyValuer{}),
+				slog.Attr{},
+				slog.Group("req", slog.Any("headers", emptyValuer{}), slog.Int("n", 1)),
+			)
+		return buf.String()
+	}
+
+	// Empty strings are always written, empty groups and empty attributes are dropped by default.
+	assert.Equal(t, "INFO\tmsg name=\"\" req.n=1\n", log())
+	assert.Equal(t, "INFO\tmsg tags={} name=\"\" user={} req.headers={} req.n=1\n", log(WithEmitEmpty(true)))
+	assert.Equal(t, "INFO\tmsg req.n=1 tags={} name=\"\" user={} req.headers={}\n",
+		log(WithEmitEmpty(true), WithPriorityKeys("req.n")))
+}
+
+func TestHandlerOnRecord(t *testing.T) {
+	type call struct {
+		level slog.Level
+		n     int
+	}
+	var calls []call
+	onRecord := func(level slog.Level, n int) {
+		calls = append(calls, call{level, n})
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithOnRecord(onRecord)))
+	logger.Info("hello")
+	logger.Debug("skipped")
+	logger.Error("failed", "k", 1)
+	assert.Equal(t, []call{{slog.LevelInfo, len("INFO\thello\n")}, {slog.LevelError, len("ERROR\tfailed k=1\n")}}, calls)
+	assert.Equal(t, buf.Len(), calls[0].n+calls[1].n)
+
+	// It's not called when the write fails.
+	calls = nil
+	logger = slog.New(NewHandlerWithOptions(failingWriter{}, WithOnRecord(onRecord)))
+	logger.Info("hello")
+	assert.Empty(t, calls)
+}
+
+func TestHandlerRelativeTimeAttrs(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithRelativeTimeAttrs(true),
+		WithClock(func() time.Time { return now }))
+	slog.New(handler).Info("msg",
+		"now", now.Add(-500*time.Millisecond),
+		"seconds", now.Add(-3*time.Second),
+		"minutes", now.Add(-5*time.Minute-30*time.Second),
+		"hours", now.Add(-2*time.Hour),
+		"days", now.Add(-50*time.Hour),
+		"in_seconds", now.Add(10*time.Second),
+		"in_minutes", now.Add(5*time.Minute),
+		"in_days", now.Add(7*24*time.Hour),
+	)
+	assert.Equal(t, "INFO\tmsg now=\"now\" seconds=\"3s ago\" minutes=\"5m ago\" hours=\"2h ago\" days=\"2d ago\""+
+		" in_seconds=\"in 10s\" in_minutes=\"in 5m\" in_days=\"in 7d\"\n", buf.String())
+
+	// Zero times are rendered in the TimeAttributeFormat, and the times beyond the range
+	// of time.Duration are not rendered as "now".
+	buf.Reset()
+	slog.New(handler).Info("msg",
+		"zero", time.Time{},
+		"old", time.Date(1500, 6, 1, 10, 0, 0, 0, time.UTC),
+		"far", time.Date(2500, 6, 1, 10, 0, 0, 0, time.UTC),
+	)
+	assert.Equal(t, "INFO\tmsg zero=0001-01-01T00:00:00.000Z old=\"191388d ago\" far=\"in 173855d\"\n", buf.String())
+}
+
+// valuer is a LogValuer that resolves to the given value.
+type valuer struct{ v slog.Value }
+
+func (v valuer) LogValue() slog.Value {
+	return v.v
+}
+
+func TestHandlerLogValuerKinds(t *testing.T) {
+	tm := time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf,
+		WithTimeFormat(""),
+		WithDurationFormat(DurationSeconds),
+		WithTimeAttributeFormat(time.DateOnly),
+	)
+	slog.New(handler).Info("msg",
+		"duration", valuer{slog.DurationValue(1500 * time.Millisecond)},
+		"time", valuer{slog.TimeValue(tm)},
+		// Nested LogValuers are resolved too.
+		"nested", valuer{slog.AnyValue(valuer{slog.DurationValue(time.Second)})},
+	)
+	assert.Equal(t, "INFO\tmsg duration=1.5 time=2024-06-01 nested=1\n", buf.String())
+}
+
+func TestHandlerKeyNamespace(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithKeyNamespace("svc."),
+		WithConstantAttrs(slog.String("version", "1.0")), WithExpandErrorChain(true))
+	logger := slog.New(handler).With(Tag("db"), "conn", 1).WithGroup("req")
+	logger.Info("msg", "status", 200, slog.Group("user", "id", 7), "err", fmt.Errorf("a: %w", errors.New("b")))
+	assert.Equal(t, "INFO\t[db]\tmsg svc.version=\"1.0\" svc.conn=1 svc.req.status=200 svc.req.user.id=7"+
+		" svc.req.err=\"a: b\" svc.req.err.cause=\"b\"\n", buf.String())
+
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)
+	r.AddAttrs(slog.Int("status", 200))
+	assert.Equal(t, map[string]any{"svc.version": "1.0", "svc.status": int64(200)}, handler.AttrMap(r))
+}
+
+// logfmtField is a key=value field of a logfmt line.
+type logfmtField struct {
+	key, value string
+}
+
+// parseLogfmt parses a logfmt line into fields. Quoted values are unquoted.
+func parseLogfmt(line string) ([]logfmtField, error) {
+	var fields []logfmtField
+	line = strings.TrimSuffix(line, "\n")
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok || key == "" || strings.ContainsAny(key, " \"") {
+			return nil, fmt.Errorf("invalid field at %q", line)
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value at %q: %w", rest, err)
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
+		}
+		if rest != "" && rest[0] != ' ' {
+			return nil, fmt.Errorf("missing separator at %q", rest)
+		}
+		fields = append(fields, logfmtField{key, value})
+		line = rest
+	}
+	return fields, nil
+}
+
+func TestHandlerFullyKeyed(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf,
+		WithTimeFormat(time.DateTime),
+		WithTimeInUTC(true),
+		WithAddSource(true),
+		WithComponent("api"),
+		WithComponentWidth(8),
+		WithFullyKeyed(true),
+	)
+	logger := slog.New(handler).With(Tag("db"), "conn", 1)
+	logger.Warn("query failed", "table", "users", "elapsed_ms", 12.5)
+
+	fields, err := parseLogfmt(buf.String())
+	assert.NoError(t, err, buf.String())
+	var keys []string
+	values := map[string]string{}
+	for _, f := range fields {
+		keys = append(keys, f.key)
+		values[f.key] = f.value
+	}
+	assert.Equal(t, []string{"time", "level", "component", "tag", "source", "msg", "conn", "table", "elapsed_ms"}, keys)
+	_, err = time.Parse(time.DateTime, values["time"])
+	assert.NoError(t, err)
+	assert.Equal(t, "WARN", values["level"])
+	assert.Equal(t, "api", values["component"])
+	assert.Equal(t, "db", values["tag"])
+	assert.Regexp(t, `main_test\.go:\d+$`, values["source"])
+	assert.Equal(t, "query failed", values["msg"])
+	assert.Equal(t, "1", values["conn"])
+	assert.Equal(t, "users", values["table"])
+	assert.Equal(t, "12.5", values["elapsed_ms"])
+
+	// Without the timestamp the level comes first.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithFullyKeyed(true), WithTagMode(TagAttr))
+	slog.New(handler).With(Tag("db")).Info("msg")
+	assert.Equal(t, "level=INFO tag=db msg=\"msg\"\n", buf.String())
+}
+
+func TestHandlerLevelComparator(t *testing.T) {
+	// A scheme where lower levels are more severe, like syslog: 0 is the most severe.
+	inverted := func(level, threshold slog.Level) bool {
+		return level <= threshold
+	}
+
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithLevel(slog.Level(3)), WithLevelComparator(inverted),
+		WithLevelLabels(map[slog.Level]string{0: "EMERG", 3: "ERR", 6: "INFO"}))
+	logger := slog.New(handler)
+	ctx := context.Background()
+	logger.Log(ctx, 0, "emergency")
+	logger.Log(ctx, 3, "error")
+	logger.Log(ctx, 6, "info")
+	assert.Equal(t, "EMERG\temergency\nERR\terror\n", buf.String())
+
+	// The comparator applies to the context level too.
+	ctx = ContextWithLevel(ctx, slog.Level(0))
+	assert.True(t, handler.Enabled(ctx, 0))
+	assert.False(t, handler.Enabled(ctx, 3))
+
+	// The comparator applies to LevelOptions and SourceMinLevel too.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithLevel(slog.Level(6)), WithLevelComparator(inverted),
+		WithLevelLabels(map[slog.Level]string{0: "EMERG", 3: "ERR", 6: "INFO"}),
+		WithAddSource(true), WithSourceMinLevel(slog.Level(3)),
+		WithLevelOptions(slog.Level(3), WithConstantAttrs(slog.String("alert", "yes"))),
+		WithLevelOptions(slog.Level(0), WithConstantAttrs(slog.String("page", "yes"))))
+	logger = slog.New(handler).WithGroup("g")
+	ctx = context.Background()
+	for _, level := range []slog.Level{0, 3, 6} {
+		var pcs [1]uintptr
+		runtime.Callers(1, pcs[:])
+		r := slog.NewRecord(time.Time{}, level, "msg", pcs[0])
+		r.AddAttrs(slog.Int("n", int(level)))
+		assert.NoError(t, logger.Handler().Handle(ctx, r))
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if assert.Len(t, lines, 3) {
+		assert.Regexp(t, `^EMERG\t\S*main_test\.go:\d+\tmsg page="yes" g\.n=0$`, lines[0])
+		assert.Regexp(t, `^ERR\t\S*main_test\.go:\d+\tmsg alert="yes" g\.n=3$`, lines[1])
+		assert.Equal(t, "INFO\tmsg g.n=6", lines[2])
+	}
+}
+
+func TestHandlerSeparateTags(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithSeparateTags(true))
+	logger := slog.New(handler)
+	db, http := logger.With(Tag("db")), logger.With(Tag("http"))
+
+	db.Info("one")
+	db.With("k", 1).Info("two")
+	http.Info("three")
+	logger.Info("untagged")
+	logger.Info("untagged")
+	db.Info("four")
+	assert.Equal(t, "INFO\t[db]\tone\nINFO\t[db]\ttwo k=1\n\n"+
+		"INFO\t[http]\tthree\n\n"+
+		"INFO\tuntagged\nINFO\tuntagged\n\n"+
+		"INFO\t[db]\tfour\n", buf.String())
+
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithSeparateTags(true), WithTagSeparator("---\n"))
+	logger = slog.New(handler)
+	logger.With(Tag("a")).Info("one")
+	logger.With(Tag("a"), Tag("b")).Info("two")
+	assert.Equal(t, "INFO\t[a]\tone\n---\nINFO\t[a]\t[b]\ttwo\n", buf.String())
+}
+
+func TestHandlerRawTagKey(t *testing.T) {
+	// Only attributes created by Tag are tags, even if other attributes use the same key.
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat("")))
+	logger.With("__tag__", "raw", Tag("db")).Info("msg", "__tag__", "record")
+	assert.Equal(t, "INFO\t[db]\tmsg __tag__=\"raw\" __tag__=\"record\"\n", buf.String())
+}
+
+func TestHandlerWithTemporaryLevel(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""))
+	logger := slog.New(handler)
+
+	func() {
+		debugHandler, restore := handler.WithTemporaryLevel(slog.LevelDebug)
+		defer restore()
+		debugLogger := slog.New(debugHandler)
+		debugLogger.Debug("inside")
+		logger.Debug("original")
+
+		restore()
+		restore()
+		debugLogger.Debug("restored")
+		debugLogger.Info("info")
+	}()
+	logger.Debug("outside")
+	assert.Equal(t, "DEBUG\tinside\nINFO\tinfo\n", buf.String())
+}
+
+// flushRecorder counts the Flush calls.
+type flushRecorder struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (w *flushRecorder) Flush() error {
+	w.flushes++
+	return nil
+}
+
+func TestCloseFlushesWriter(t *testing.T) {
+	out := &flushRecorder{}
+	handler := NewHandler(out, &Options{TimeFormat: ""})
+	slog.New(handler).Info("Test message")
+	assert.NoError(t, handler.Close())
+	assert.Equal(t, 1, out.flushes)
+}
+
+func TestCloseEndMarker(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithEndMarker("--- end of log ---\n"))
+	logger := slog.New(handler)
+	logger.Info("First")
+	logger.Info("Second")
+	assert.Equal(t, "INFO\tFirst\nINFO\tSecond\n", buf.String())
+
+	// The marker is written once, also when Close is called on a derived handler.
+	assert.NoError(t, handler.Close())
+	assert.NoError(t, logger.With("a", 1).Handler().(*Handler).Close())
+	assert.Equal(t, "INFO\tFirst\nINFO\tSecond\n--- end of log ---\n", buf.String())
+
+	// Records logged after Close follow the marker.
+	logger.Info("Late")
+	assert.NoError(t, handler.Close())
+	assert.Equal(t, "INFO\tFirst\nINFO\tSecond\n--- end of log ---\nINFO\tLate\n", buf.String())
+
+	// A failed marker is written by the next Close.
+	w := &flakyWriter{fail: 1}
+	handler = NewHandlerWithOptions(w, WithTimeFormat(""), WithEndMarker("END\n"))
+	assert.EqualError(t, handler.Close(), "write failed")
+	assert.NoError(t, handler.Close())
+	assert.NoError(t, handler.Close())
+	assert.Equal(t, "END\n", w.String())
+
+	// The marker is framed like the records, and it's not passed to OnRecord.
+	buf.Reset()
+	records := 0
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithEndMarker("END"), WithFramingMode(FramingLengthPrefix),
+		WithOnRecord(func(slog.Level, int) { records++ }))
+	slog.New(handler).Info("msg")
+	assert.NoError(t, handler.Close())
+	assert.Equal(t, "\x00\x00\x00\x08INFO\tmsg\x00\x00\x00\x03END", buf.String())
+	assert.Equal(t, 1, records)
+}
+
+// slowFlushWriter is a slowWriter that counts the Flush calls.
+type slowFlushWriter struct {
+	slowWriter
+	flushes int
+}
+
+func (w *slowFlushWriter) Flush() error {
+	w.flushes++
+	return nil
+}
+
+func TestCloseWriteTimeout(t *testing.T) {
+	w := &slowFlushWriter{slowWriter: slowWriter{release: make(chan struct{})}}
+	handler := NewHandlerWithOptions(w, WithTimeFormat(""), WithWriteTimeout(10*time.Millisecond), WithEndMarker("END\n"))
+	assert.ErrorIs(t, handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "first", 0)), ErrWriteTimeout)
+
+	// Close doesn't write or flush while the timed out write is blocked.
+	assert.ErrorIs(t, handler.Close(), ErrWriteTimeout)
+	assert.Equal(t, 0, w.flushes)
+
+	// Close waits for the pending write to return.
+	close(w.release)
+	assert.NoError(t, handler.Close())
+	assert.Equal(t, 1, w.flushes)
+	assert.Equal(t, "INFO\tfirst\nEND\n", w.String())
+}
+
+func TestBuildInfo(t *testing.T) {
+	info := &debug.BuildInfo{
+		Main:     debug.Module{Path: "example.com/app", Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{{Key: "vcs", Value: "git"}, {Key: "vcs.revision", Value: "4f2c1ab"}},
+	}
+	defer func(orig func() slog.Attr) { buildInfoAttr = orig }(buildInfoAttr)
+	calls := 0
+	buildInfoAttr = func() slog.Attr {
+		calls++
+		return buildAttr(info, true)
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithBuildInfo(true)))
+	logger.Info("First", "n", 1)
+	logger.Info("Second", "n", 2)
+	assert.Equal(t,
+		"INFO\tFirst build.version=\"v1.2.3\" build.revision=\"4f2c1ab\" n=1\n"+
+			"INFO\tSecond build.version=\"v1.2.3\" build.revision=\"4f2c1ab\" n=2\n",
+		buf.String())
+	assert.Equal(t, 1, calls, "the build info is formatted once")
+
+	// The build info is not available.
+	assert.Equal(t, slog.Attr{}, buildAttr(nil, false))
+	assert.Equal(t, slog.Attr{}, buildAttr(&debug.BuildInfo{}, true))
+	buildInfoAttr = func() slog.Attr { return slog.Attr{} }
+	buf.Reset()
+	slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithBuildInfo(true))).Info("Test message")
+	assert.Equal(t, "INFO\tTest message\n", buf.String())
+}
+
+func TestSortAttrs(t *testing.T) {
+	log := func(mode SortMode) string {
+		var buf bytes.Buffer
+		handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithSortAttrs(mode))
+		logger := slog.New(handler).With("zone", "eu", slog.Group("req", "path", "/", "id", 7))
+		logger.Info("Test message",
+			"req_count", 3,
+			slog.Group("resp", "status", 200, slog.Group("body", "size", 10), "bytes", 42),
+			"app", "api",
+			slog.Group("req", "method", "GET"),
+		)
+		return buf.String()
+	}
+
+	assert.Equal(t,
+		"INFO\tTest message zone=\"eu\" req.path=\"/\" req.id=7 req_count=3 resp.status=200 resp.body.size=10 resp.bytes=42 app=\"api\" req.method=\"GET\"\n",
+		log(SortNone))
+	// Sorting by the full key interleaves the groups with the other attributes.
+	assert.Equal(t,
+		"INFO\tTest message app=\"api\" req.id=7 req.method=\"GET\" req.path=\"/\" req_count=3 resp.body.size=10 resp.bytes=42 resp.status=200 zone=\"eu\"\n",
+		log(SortByKey))
+	assert.Equal(t,
+		"INFO\tTest message app=\"api\" req_count=3 zone=\"eu\" req.id=7 req.method=\"GET\" req.path=\"/\" resp.bytes=42 resp.status=200 resp.body.size=10\n",
+		log(SortGrouped))
+
+	// PriorityKeys are still written first.
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithSortAttrs(SortGrouped), WithPriorityKeys("resp.status"))
+	slog.New(handler).Info("Test message", "b", 1, "a", 2, slog.Group("resp", "status", 200, "bytes", 42))
+	assert.Equal(t, "INFO\tTest message resp.status=200 a=2 b=1 resp.bytes=42\n", buf.String())
+}
+
+func TestFloatPrecision(t *testing.T) {
+	tests := []struct {
+		precision int
+		value     float64
+		expected  string
+	}{
+		{0, 3.14159, "3.14159"},
+		{0, 1.0 / 3, "0.3333333333333333"},
+		{2, 3.14159, "3.14"},
+		{2, 2.5, "2.50"},
+		{2, 1.005, "1.00"}, // the binary value is slightly less than 1.005
+		{2, -0.001, "-0.00"},
+		{3, 1.0 / 3, "0.333"},
+		{3, 1e21, "1000000000000000000000.000"},
+		{3, 123456.7895, "123456.789"},
+		{1, math.Inf(1), "+Inf"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithFloatPrecision(tt.precision)))
+		logger.Info("Test message", "value", tt.value)
+		assert.Equal(t, "INFO\tTest message value="+tt.expected+"\n", buf.String(), "precision %d, value %v", tt.precision, tt.value)
+	}
+}
+
+func TestFloatPrecisionDurationSeconds(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithFloatPrecision(2), WithDurationFormat(DurationSeconds)))
+	logger.Info("Test message", "f", 1.234567, "d", 1234567*time.Microsecond)
+	assert.Equal(t, "INFO\tTest message f=1.23 d=1.23\n", buf.String())
+}
+
+func TestGroupSeparatorAndEscapeKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{"default", nil, `req.a.b=1 req.c/d=2 req.e\f=3 req.g.h=4`},
+		{"escaped", []Option{WithEscapeKeys(true)}, `req.a\.b=1 req.c/d=2 req.e\\f=3 req.g.h=4`},
+		{"slash", []Option{WithGroupSeparator("/")}, `req/a.b=1 req/c/d=2 req/e\f=3 req/g/h=4`},
+		{"slash escaped", []Option{WithGroupSeparator("/"), WithEscapeKeys(true)}, `req/a.b=1 req/c\/d=2 req/e\\f=3 req/g/h=4`},
+		{"multi-byte escaped", []Option{WithGroupSeparator("::"), WithEscapeKeys(true)}, `req::a.b=1 req::c/d=2 req::e\\f=3 req::g::h=4`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := append([]Option{WithTimeFormat("")}, tt.opts...)
+			slog.New(NewHandlerWithOptions(&buf, opts...)).WithGroup("req").Info("Test message",
+				"a.b", 1, "c/d", 2, `e\f`, 3, slog.Group("g", "h", 4))
+			assert.Equal(t, "INFO\tTest message "+tt.expected+"\n", buf.String())
+		})
+	}
+
+	// The escaped keys are not ambiguous, so StrictKeys accepts them.
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithStrictKeys(true), WithEscapeKeys(true),
+		WithSortAttrs(SortGrouped), WithPriorityKeys(`g\.x.y`))
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "Test message", 0)
+	r.AddAttrs(slog.Int("a", 1), slog.Group("g.x", "z", 2, "y", 3), slog.Group("g", "w", 4))
+	assert.NoError(t, handler.Handle(context.Background(), r))
+	assert.Equal(t, "INFO\tTest message g\\.x.y=3 a=1 g.w=4 g\\.x.z=2\n", buf.String())
+	assert.Equal(t, map[string]any{"a": int64(1), `g\.x.z`: int64(2), `g\.x.y`: int64(3), "g.w": int64(4)}, handler.AttrMap(r))
+
+	handler = NewHandlerWithOptions(&buf, WithStrictKeys(true), WithGroupSeparator("/"))
+	r = slog.NewRecord(time.Time{}, slog.LevelInfo, "Test message", 0)
+	r.AddAttrs(slog.Int("a.b", 1), slog.Int("c/d", 2))
+	assert.ErrorIs(t, handler.Handle(context.Background(), r), ErrInvalidKey)
+	assert.ErrorContains(t, handler.Handle(context.Background(), r), `key "c/d" contains "/"`)
+}
+
+// panickingValuer is a LogValuer that panics.
+type panickingValuer struct{}
+
+func (panickingValuer) LogValue() slog.Value {
+	panic("boom")
+}
+
+// panickingError is an error whose Error method panics.
+type panickingError struct{}
+
+func (panickingError) Error() string {
+	panic("no message")
+}
+
+func TestHandlerAttrPanic(t *testing.T) {
+	var buf bytes.Buffer
+	var errs []error
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithExpandErrorChain(true), WithOnError(func(err error) {
+		errs = append(errs, err)
+	}))
+	logger := slog.New(handler)
+
+	assert.NotPanics(t, func() {
+		logger.Info("Test message", "a", 1, "v", panickingValuer{}, "err", panickingError{}, "b", 2)
+	})
+	assert.Equal(t, "INFO\tTest message a=1 v=\"!PANIC(boom)\" err=\"!PANIC(no message)\" b=2\n", buf.String())
+	if assert.Len(t, errs, 2) {
+		assert.ErrorIs(t, errs[0], ErrAttrPanic)
+		assert.EqualError(t, errs[1], "slogtfmt: attribute value panicked: no message")
+	}
+
+	// Panicking TypeFormatters are recovered as well.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithTypeFormatters(func(v any) (slog.Value, bool) {
+		panic("formatter")
+	}))
+	slog.New(handler).Info("Test message", "p", point{1, 2})
+	assert.Equal(t, "INFO\tTest message p=\"!PANIC(formatter)\"\n", buf.String())
+}
+
+// recursiveValuer is a LogValuer that returns a group containing itself.
+type recursiveValuer struct{ n int }
+
+func (v recursiveValuer) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("n", v.n), slog.Any("next", recursiveValuer{v.n + 1}))
+}
+
+func TestHandlerMaxGroupDepth(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithMaxGroupDepth(2))).WithGroup("req")
+
+	logger.Info("Test message", slog.Group("a", "x", 1, slog.Group("b", "y", 2, slog.Group("c", "z", 3))), "d", 4)
+	assert.Equal(t, "INFO\tTest message req.a.x=1 req.a.b.y=2 req.a.b.c={...} req.d=4\n", buf.String())
+
+	// Recursive values are truncated instead of overflowing the stack.
+	buf.Reset()
+	logger.Info("Test message", "v", recursiveValuer{})
+	assert.Equal(t, "INFO\tTest message req.v.n=0 req.v.next.n=1 req.v.next.next={...}\n", buf.String())
+
+	// The collected attributes are truncated as well.
+	buf.Reset()
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithMaxGroupDepth(1), WithSortAttrs(SortByKey), WithStrictKeys(true))
+	slog.New(handler).Info("Test message", "v", recursiveValuer{})
+	assert.Equal(t, "INFO\tTest message v.n=0 v.next={...}\n", buf.String())
+
+	// The truncated groups without a key are written under the key of the enclosing group.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithMaxGroupDepth(1)))
+	logger.Info("Test message", slog.Group("req", slog.Group("", "status", 200)), slog.Group("", slog.Group("", "x", 1)))
+	assert.Equal(t, "INFO\tTest message req={...} !BADKEY={...}\n", buf.String())
+}
+
+func TestHandlerDeltaTimestamps(t *testing.T) {
+	var buf bytes.Buffer
+	tm := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithDeltaTimestamps(true), WithClock(func() time.Time { return tm }))
+
+	handle := func(h slog.Handler, d time.Duration, msg string) {
+		tm = tm.Add(d)
+		assert.NoError(t, h.Handle(context.Background(), slog.NewRecord(tm, slog.LevelInfo, msg, 0)))
+	}
+	handle(handler, 0, "start")
+	handle(handler, 3*time.Millisecond, "step 1")
+	handle(handler.WithGroup("g"), 1500*time.Millisecond, "step 2")
+	// Records without a time use the clock.
+	tm = tm.Add(time.Second)
+	assert.NoError(t, handler.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "no time", 0)))
+	// Records out of order have a negative delta.
+	handle(handler, -2*time.Second, "late")
+
+	expected := "+0s\tINFO\tstart\n" +
+		"+3ms\tINFO\tstep 1\n" +
+		"+1.5s\tINFO\tstep 2\n" +
+		"+1s\tINFO\tno time\n" +
+		"-2s\tINFO\tlate\n"
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithDeltaTimestamps(true), WithDurationFormat(DurationSeconds), WithFullyKeyed(true))
+	handle(handler, 0, "start")
+	handle(handler, 3*time.Millisecond, "step")
+	assert.Equal(t, "delta=+0 level=INFO msg=\"start\"\ndelta=+0.003 level=INFO msg=\"step\"\n", buf.String())
+
+	// FormatRecord doesn't move the time of the last written record.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithDeltaTimestamps(true))
+	handle(handler, 0, "start")
+	line, err := handler.FormatRecord(context.Background(), slog.NewRecord(tm.Add(5*time.Second), slog.LevelInfo, "formatted", 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "+5s\tINFO\tformatted\n", string(line))
+	handle(handler, time.Second, "step")
+	assert.Equal(t, "+0s\tINFO\tstart\n+1s\tINFO\tstep\n", buf.String())
+}
+
+func TestHandlerHeaderLine(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(time.TimeOnly), WithHeaderLine(true))
+	assert.Equal(t, []string{"time", "level", "tag", "message"}, handler.Columns())
+	assert.Equal(t, "# columns: time level tag message\n", buf.String(), "the header is written at construction")
+
+	logger := slog.New(handler)
+	logger.Info("first")
+	logger.With(Tag("db")).Info("second")
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, "# columns: time level tag message", lines[0])
+	assert.Len(t, lines, 4)
+
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithHeaderLine(true), WithAddSource(true),
+		WithAddElapsed(true), WithComponent("api"), WithTagMode(TagAttr))
+	assert.Equal(t, []string{"elapsed", "level", "component", "source", "message"}, handler.Columns())
+	slog.New(handler).Info("first")
+	assert.True(t, strings.HasPrefix(buf.String(), "# columns: elapsed level component source message\n"))
+
+	// The FullyKeyed lines describe themselves.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithHeaderLine(true), WithFullyKeyed(true))
+	slog.New(handler).Info("first")
+	assert.Equal(t, "level=INFO msg=\"first\"\n", buf.String())
+
+	// The header describes the base options, not the LevelOptions of the first record,
+	// and lists the PositionalKeys.
+	buf.Reset()
+	handler = NewHandlerWithOptions(&buf, WithTimeFormat(""), WithHeaderLine(true), WithPositionalKeys("status", "path"),
+		WithLevelOptions(slog.LevelError, WithAddSource(true)))
+	slog.New(handler).Error("failed", "status", 500, "path", "/")
+	assert.True(t, strings.HasPrefix(buf.String(), "# columns: level tag message status path\nERROR\t"), buf.String())
+
+	// A failed header is reported and written before the first record.
+	var errs []error
+	w := &flakyWriter{fail: 1}
+	handler = NewHandlerWithOptions(w, WithTimeFormat(""), WithHeaderLine(true),
+		WithOnError(func(err error) { errs = append(errs, err) }))
+	assert.Len(t, errs, 1)
+	slog.New(handler).Info("first")
+	assert.Equal(t, "# columns: level tag message\nINFO\tfirst\n", w.String())
+}
+
+// flakyWriter fails the first fail writes.
+type flakyWriter struct {
+	bytes.Buffer
+	fail int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if w.fail > 0 {
+		w.fail--
+		return 0, errors.New("write failed")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestHandlerLevelOptions(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf,
+		WithTimeFormat(""),
+		WithLevel(slog.LevelDebug),
+		WithConstantAttrs(slog.String("app", "api")),
+		WithLevelOptions(slog.LevelError, WithAddSource(true), WithConstantAttrs(slog.String("app", "api"), slog.String("alert", "yes"))),
+		WithLevelOptions(slog.LevelDebug, WithTimeFormat(time.TimeOnly), WithTimeInUTC(true), WithLevel(slog.LevelError)),
+		WithLevelOptions(slog.LevelInfo),
+	)
+	logger := slog.New(handler).With("conn", 1)
+	tm := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError, slog.LevelError + 4} {
+		var pcs [1]uintptr
+		runtime.Callers(1, pcs[:])
+		r := slog.NewRecord(tm, level, "Test message", pcs[0])
+		assert.NoError(t, logger.Handler().Handle(context.Background(), r))
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if assert.Len(t, lines, 5) {
+		// The level options don't change the level threshold.
+		assert.Equal(t, "10:00:00\tDEBUG\tTest message app=\"api\" conn=1", lines[0])
+		assert.Equal(t, "INFO\tTest message app=\"api\" conn=1", lines[1])
+		assert.Equal(t, "WARN\tTest message app=\"api\" conn=1", lines[2])
+		assert.Regexp(t, `^ERROR\t\S*main_test\.go:\d+\tTest message app="api" alert="yes" conn=1$`, lines[3])
+		assert.Regexp(t, `^ERROR\+4\t\S*main_test\.go:\d+\tTest message app="api" alert="yes" conn=1$`, lines[4])
+	}
+}
+
+func TestHandlerPositionalKeys(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithPositionalKeys("method", "req.status", "latency_ms", "path"))
+	logger := slog.New(handler)
+
+	logger.Info("request", "path", "/a", "method", "GET", "latency_ms", 12.5, slog.Group("req", "status", 200))
+	logger.Info("request", "method", "POST", "user", "alice", "path", "/b")
+	logger.Info("request")
+	expected := "INFO\trequest \"GET\" 200 12.5 \"/a\"\n" +
+		"INFO\trequest \"POST\" \"\" \"\" \"/b\" user=\"alice\"\n" +
+		"INFO\trequest \"\" \"\" \"\" \"\"\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestHandlerPositionalKeysLeafOptions(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithInferUnits(true), WithExpandErrorChain(true),
+		WithPositionalKeys("latency_ms", "err", "p"))
+	logger := slog.New(handler)
+
+	logger.Info("request", "latency_ms", 12, "err", fmt.Errorf("load: %w", errors.New("refused")), "p", panickingError{}, "a", 1)
+	assert.Equal(t, "INFO\trequest 12ms \"load: refused\" \"!PANIC(no message)\" err.cause=\"refused\" a=1\n", buf.String())
+}
+
+func TestHandlerJoinTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		tags     []string
+		expected string
+	}{
+		{"separate two", nil, []string{"api", "v2"}, "INFO\t[api]\t[v2]\tTest message\n"},
+		{"separate three", nil, []string{"api", "v2", "users"}, "INFO\t[api]\t[v2]\t[users]\tTest message\n"},
+		{"joined one", []Option{WithJoinTags("/")}, []string{"api"}, "INFO\t[api]\tTest message\n"},
+		{"joined two", []Option{WithJoinTags("/")}, []string{"api", "v2"}, "INFO\t[api/v2]\tTest message\n"},
+		{"joined three", []Option{WithJoinTags(".")}, []string{"api", "v2", "users"}, "INFO\t[api.v2.users]\tTest message\n"},
+		{"joined attr", []Option{WithJoinTags("/"), WithTagMode(TagAttr)}, []string{"api", "v2", "users"}, "INFO\tTest message tag=\"api/v2/users\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(NewHandlerWithOptions(&buf, append([]Option{WithTimeFormat("")}, tt.opts...)...))
+			for _, tag := range tt.tags {
+				logger = logger.With(Tag(tag))
+			}
+			logger.Info("Test message")
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+
+	handler := NewHandlerWithOptions(&bytes.Buffer{}, WithJoinTags("/"), WithTagMode(TagAttr))
+	h := handler.WithAttrs([]slog.Attr{Tag("api")}).WithAttrs([]slog.Attr{Tag("v2")}).(*Handler)
+	assert.Equal(t, map[string]any{"tag": "api/v2"}, h.AttrMap(slog.NewRecord(time.Time{}, slog.LevelInfo, "Test message", 0)))
+}
+
+func TestHandlerSourceAsURI(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAddSource(true), WithSourceAsURI(true), WithSourceRoot("/")))
+	logger.Info("Test message")
+	assert.Regexp(t, `^INFO\tfile:///\S*/main_test\.go:\d+\tTest message\n$`, buf.String())
+
+	tests := []struct {
+		path, expected string
+	}{
+		{"/home/me/app/main.go", "file:///home/me/app/main.go"},
+		{"/home/me/my app/main.go", "file:///home/me/my%20app/main.go"},
+		{"/src/a#b/100%/c?.go", "file:///src/a%23b/100%25/c%3F.go"},
+		{"/src/ünï/main.go", "file:///src/%C3%BCn%C3%AF/main.go"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, fileURI(tt.path))
+	}
+
+	// Relative paths are made absolute.
+	abs, err := filepath.Abs("main.go")
+	assert.NoError(t, err)
+	assert.Equal(t, "file://"+filepath.ToSlash(abs), fileURI("main.go"))
+}
+
+func TestHandlerDedupKeys(t *testing.T) {
+	var buf bytes.Buffer
+	var errs []error
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithDedupKeys(true), WithWarnShadowedKeys(true),
+		WithOnError(func(err error) { errs = append(errs, err) }))
+	logger := slog.New(handler).With("user", "alice", "conn", 1).With("conn", 2)
+
+	logger.Info("Test message", "user", "bob", "id", 7)
+	logger.Info("Test message", "user", "carol", "id", 8, "id", 9)
+	logger.WithGroup("req").Info("Test message", "user", "dave")
+	expected := "INFO\tTest message conn=2 user=\"bob\" id=7\n" +
+		"INFO\tTest message conn=2 user=\"carol\" id=9\n" +
+		"INFO\tTest message user=\"alice\" conn=2 req.user=\"dave\"\n"
+	assert.Equal(t, expected, buf.String())
+
+	// The shadowed key is reported once; the replaced WithAttrs attribute and the duplicate
+	// record attribute are not reported.
+	if assert.Len(t, errs, 1) {
+		assert.ErrorIs(t, errs[0], ErrShadowedKey)
+		assert.EqualError(t, errs[0], `slogtfmt: record attribute shadows a handler attribute: "user"`)
+	}
+
+	// The keys are compared as written, so an escaped key doesn't replace a group member.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithDedupKeys(true), WithEscapeKeys(true)))
+	logger.Info("msg", slog.Group("a", "b", 1), "a.b", 2, "a.b", 3)
+	assert.Equal(t, "INFO\tmsg a.b=1 a\\.b=3\n", buf.String())
+}
+
+func TestHandlerAutoTagFromPackage(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithAutoTagFromPackage(true))
+	logger := slog.New(handler)
+
+	logger.Info("Local", "n", 1)
+	assert.Equal(t, "INFO\t[slogtfmt]\tLocal n=1\n", buf.String())
+
+	// Take the program counter of a function in another package, which calls the closure.
+	var pcs [1]uintptr
+	strings.Map(func(r rune) rune {
+		runtime.Callers(2, pcs[:])
+		return r
+	}, "x")
+	buf.Reset()
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "Remote", pcs[0])
+	assert.NoError(t, handler.Handle(context.Background(), r))
+	assert.Equal(t, "INFO\t[strings]\tRemote\n", buf.String())
+
+	// The explicit tags take precedence.
+	buf.Reset()
+	logger.With(Tag("db")).Info("Query")
+	assert.Equal(t, "INFO\t[db]\tQuery\n", buf.String())
+	buf.Reset()
+	logger.InfoContext(ContextWithTag(context.Background(), "http"), "Request")
+	assert.Equal(t, "INFO\t[http]\tRequest\n", buf.String())
+
+	// Records without a program counter have no tag.
+	buf.Reset()
+	assert.NoError(t, handler.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "No caller", 0)))
+	assert.Equal(t, "INFO\tNo caller\n", buf.String())
+}
+
+func TestPackageName(t *testing.T) {
+	pc, _, _, _ := runtime.Caller(0)
+	name, ok := packageName(pc)
+	assert.True(t, ok)
+	assert.Equal(t, "slogtfmt", name)
+}
+
+func TestHandlerFallbackHandler(t *testing.T) {
+	var fallback bytes.Buffer
+	fallbackHandler := NewHandlerWithOptions(&fallback, WithTimeFormat(""))
+	logger := slog.New(NewHandlerWithOptions(failingWriter{}, WithTimeFormat(""), WithFallbackHandler(fallbackHandler)))
+
+	err := logger.Handler().Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "Lost", 0))
+	assert.EqualError(t, err, "write failed")
+	assert.Equal(t, "INFO\tLost\n", fallback.String())
+
+	// The attributes, groups and tags of the Handler are added to the fallback.
+	fallback.Reset()
+	logger.With(Tag("db"), "id", 1).WithGroup("q").Error("Query failed", "table", "users")
+	assert.Equal(t, "ERROR\tQuery failed tag=\"db\" id=1 q.table=\"users\"\n", fallback.String())
+
+	// The records with malformed keys are forwarded with StrictKeys.
+	var buf bytes.Buffer
+	fallback.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithStrictKeys(true), WithFallbackHandler(fallbackHandler)))
+	logger.Info("Malformed", "a.b", 1)
+	assert.Empty(t, buf.String())
+	assert.Equal(t, "INFO\tMalformed a.b=1\n", fallback.String())
+}
+
+func TestHandlerFallbackHandlerRecursion(t *testing.T) {
+	// The fallback fails as well and forwards the records back to the Handler.
+	var calls int
+	var handler *Handler
+	fallback := &funcHandler{handle: func(ctx context.Context, r slog.Record) error {
+		calls++
+		return handler.Handle(ctx, r)
+	}}
+	handler = NewHandlerWithOptions(failingWriter{}, WithFallbackHandler(fallback))
+
+	err := handler.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "Lost", 0))
+	assert.EqualError(t, err, "write failed\nwrite failed")
+	assert.Equal(t, 1, calls)
+}
+
+// funcHandler is a slog.Handler that calls handle for each record.
+type funcHandler struct {
+	handle func(context.Context, slog.Record) error
+}
+
+func (h *funcHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *funcHandler) Handle(ctx context.Context, r slog.Record) error { return h.handle(ctx, r) }
+
+func (h *funcHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *funcHandler) WithGroup(string) slog.Handler { return h }
+
+func TestHandlerMultilineAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf,
+		WithTimeFormat(""),
+		WithConstantAttrs(slog.String("app", "api")),
+		WithLevelOptions(slog.LevelError, WithMultilineAttrs(true)),
+	)).With("user", "alice")
+
+	logger.Info("Request", "status", 200)
+	assert.Equal(t, "INFO\tRequest app=\"api\" user=\"alice\" status=200\n", buf.String())
+
+	buf.Reset()
+	logger.WithGroup("db").Error("Query failed", "table", "users", "err", errors.New("timeout"))
+	assert.Equal(t,
+		"ERROR\tQuery failed\n"+
+			"\tapp=\"api\"\n"+
+			"\tuser=\"alice\"\n"+
+			"\tdb.table=\"users\"\n"+
+			"\tdb.err=timeout\n",
+		buf.String())
+
+	// Records without attributes stay on one line.
+	buf.Reset()
+	slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithMultilineAttrs(true))).Error("Failed")
+	assert.Equal(t, "ERROR\tFailed\n", buf.String())
+
+	// The delimiters wrap the attribute lines.
+	buf.Reset()
+	slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithMultilineAttrs(true), WithAttrsBrackets(true))).
+		Error("Failed", "a", 1, "b", 2)
+	assert.Equal(t, "ERROR\tFailed\n\t{a=1\n\tb=2}\n", buf.String())
+}
+
+// stringerFunc is a func type with a String method, which panics for a nil func.
+type stringerFunc func() string
+
+func (f stringerFunc) String() string { return f() }
+
+func TestHandlerOpaqueValues(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithOpaqueValues(true)))
+
+	ch := make(chan int)
+	logger.Info("Opaque", "fn", func() {}, "ch", ch, "recv", (<-chan int)(ch), "nilfn", stringerFunc(nil))
+	assert.Equal(t, "INFO\tOpaque fn=func(...) ch=chan(...) recv=chan(...) nilfn=func(...)\n", buf.String())
+
+	// The other values are written as usual.
+	buf.Reset()
+	logger.Info("Values", "n", 1, "s", []int{1, 2}, "nil", nil)
+	assert.Equal(t, "INFO\tValues n=1 s=[1 2] nil=<nil>\n", buf.String())
+
+	// The TypeFormatters take precedence.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithOpaqueValues(true),
+		WithTypeFormatters(func(v any) (slog.Value, bool) {
+			if _, ok := v.(chan int); ok {
+				return slog.StringValue("<chan int>"), true
+			}
+			return slog.Value{}, false
+		})))
+	logger.Info("Opaque", "ch", ch, "fn", func() {})
+	assert.Equal(t, "INFO\tOpaque ch=\"<chan int>\" fn=func(...)\n", buf.String())
+
+	// Without the option, the values are formatted with fmt.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat("")))
+	logger.Info("Opaque", "ch", ch)
+	assert.Regexp(t, `^INFO\tOpaque ch=0x[0-9a-f]+\n$`, buf.String())
+}
+
+func TestHandlerCorrelationKey(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithCorrelationKey("request_id"))
+	logger := slog.New(handler)
+
+	logger.With(Tag("http")).Info("Request", "request_id", "4bf92f35", "path", "/")
+	assert.Equal(t, "INFO\t[http]\t[4bf92f35]\tRequest path=\"/\"\n", buf.String())
+
+	// The records without the attribute have no correlation ID column.
+	buf.Reset()
+	logger.Info("Started", "port", 8080)
+	assert.Equal(t, "INFO\tStarted port=8080\n", buf.String())
+
+	// The attribute can be added with With, and the record attribute takes precedence.
+	buf.Reset()
+	reqLogger := logger.With("request_id", "4bf92f35")
+	reqLogger.Info("Query", "table", "users")
+	reqLogger.Info("Retry", "request_id", "a3ce929d")
+	assert.Equal(t, "INFO\t[4bf92f35]\tQuery table=\"users\"\nINFO\t[a3ce929d]\tRetry\n", buf.String())
+
+	// The key includes the groups set with WithGroup.
+	buf.Reset()
+	logger.WithGroup("req").Info("Grouped", "request_id", "4bf92f35")
+	assert.Equal(t, "INFO\tGrouped req.request_id=\"4bf92f35\"\n", buf.String())
+	assert.Equal(t, []string{"level", "tag", "request_id", "message"}, handler.Columns())
+
+	// Hostile IDs are quoted, so they can't forge lines or break the columns.
+	buf.Reset()
+	logger.Info("Request", "request_id", "x]\tINFO\n2024-01-01 ERROR\tforged")
+	assert.Equal(t, "INFO\t[\"x]\\tINFO\\n2024-01-01 ERROR\\tforged\"]\tRequest\n", buf.String())
+
+	// In the FullyKeyed mode, the correlation ID is a header field.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithCorrelationKey("request_id"), WithFullyKeyed(true)))
+	logger.Info("Request", "request_id", "4bf92f35", "path", "/")
+	assert.Equal(t, "level=INFO request_id=4bf92f35 msg=\"Request\" path=\"/\"\n", buf.String())
+
+	// AttrMap includes the correlation ID.
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "Request", 0)
+	r.AddAttrs(slog.String("request_id", "4bf92f35"), slog.Int("n", 1))
+	m := NewHandlerWithOptions(&buf, WithCorrelationKey("request_id")).AttrMap(r)
+	assert.Equal(t, map[string]any{"request_id": "4bf92f35", "n": int64(1)}, m)
+}
+
+func TestHandlerColumnWidths(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf,
+		WithTimeFormat(time.TimeOnly),
+		WithAddSource(true),
+		WithColumnWidths(ColumnWidths{Time: 10, Level: 5, Tag: 8, Source: 12}),
+	)
+	logger := slog.New(handler)
+	ts := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+
+	handle := func(logger *slog.Logger, level slog.Level, msg string, pc uintptr) {
+		assert.NoError(t, logger.Handler().Handle(context.Background(), slog.NewRecord(ts, level, msg, pc)))
+	}
+	pc, file, line, _ := runtime.Caller(0)
+	source := filepath.Base(file) + ":" + strconv.Itoa(line)
+
+	handle(logger.With(Tag("db")), slog.LevelInfo, "Query", pc)
+	handle(logger.With(Tag("database")), slog.LevelError, "Failed", pc)
+	handle(logger.With(Tag("db"), Tag("migrate")), slog.LevelWarn, "Slow", 0)
+	assert.Equal(t,
+		"12:00:00  \tINFO \t[db]    \t"+source[len(source)-12:]+"\tQuery\n"+
+			"12:00:00  \tERROR\t[databas\t"+source[len(source)-12:]+"\tFailed\n"+
+			"12:00:00  \tWARN \t[db]\t[mi\t            \tSlow\n",
+		buf.String())
+
+	// The columns without a value are padded too.
+	buf.Reset()
+	handle(logger, slog.LevelInfo, "Untagged", 0)
+	handle(logger, slog.Level(-10), "Custom level", 0)
+	assert.NoError(t, handler.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "No time", 0)))
+	assert.Equal(t,
+		"12:00:00  \tINFO \t        \t            \tUntagged\n"+
+			"12:00:00  \tDEBUG\t        \t            \tCustom level\n"+
+			"          \tINFO \t        \t            \tNo time\n",
+		buf.String())
+
+	// The widths are ignored in the FullyKeyed mode.
+	buf.Reset()
+	logger = slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithFullyKeyed(true),
+		WithColumnWidths(ColumnWidths{Level: 8, Tag: 8})))
+	logger.Info("Keyed")
+	assert.Equal(t, "level=INFO msg=\"Keyed\"\n", buf.String())
+}
+
+func TestHandlerInlineGroups(t *testing.T) {
+	attrs := []any{
+		slog.Group("", slog.String("a", "1")),
+		slog.Group("req", slog.Group("", slog.Int("status", 200), slog.Group("", slog.Int("bytes", 5)))),
+		slog.Group("", slog.Group("resp", slog.Group("", slog.String("b", "2")))),
+	}
+	tests := []struct {
+		name     string
+		opts     []Option
+		group    string
+		expected string
+	}{
+		{"top level", nil, "",
+			"INFO\tmsg a=\"1\" req.status=200 req.bytes=5 resp.b=\"2\"\n"},
+		{"in WithGroup", nil, "g",
+			"INFO\tmsg g.a=\"1\" g.req.status=200 g.req.bytes=5 g.resp.b=\"2\"\n"},
+		{"collected", []Option{WithSortAttrs(SortByKey)}, "g",
+			"INFO\tmsg g.a=\"1\" g.req.bytes=5 g.req.status=200 g.resp.b=\"2\"\n"},
+		{"group separator", []Option{WithGroupSeparator("/")}, "g",
+			"INFO\tmsg g/a=\"1\" g/req/status=200 g/req/bytes=5 g/resp/b=\"2\"\n"},
+		// The groups without a key count towards the depth, and truncated ones are written
+		// under the key of the enclosing group.
+		{"max group depth", []Option{WithMaxGroupDepth(1)}, "",
+			"INFO\tmsg a=\"1\" req={...} resp={...}\n"},
+		{"max group depth collected", []Option{WithMaxGroupDepth(1), WithSortAttrs(SortByKey)}, "g",
+			"INFO\tmsg g.a=\"1\" g.req={...} g.resp={...}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(NewHandlerWithOptions(&buf, append([]Option{WithTimeFormat("")}, tt.opts...)...))
+			if tt.group != "" {
+				logger = logger.WithGroup(tt.group)
+			}
+			logger.Info("msg", attrs...)
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+
+	// The keys of AttrMap have no stray separators either.
+	r := slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)
+	r.Add(attrs...)
+	m := NewHandlerWithOptions(&bytes.Buffer{}).WithGroup("g").(*Handler).AttrMap(r)
+	assert.Equal(t, map[string]any{"g.a": "1", "g.req.status": int64(200), "g.req.bytes": int64(5), "g.resp.b": "2"}, m)
+}
+
+func TestHandlerMultilineAttrsPositionalKeys(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandlerWithOptions(&buf, WithTimeFormat(""), WithMultilineAttrs(true),
+		WithPositionalKeys("a"), WithAttrsBrackets(true)))
+	logger.Info("m", "a", "xyz", "b", 2)
+	assert.Equal(t, "INFO\tm\n\t{\"xyz\"\n\tb=2}\n", buf.String())
+}
@@ -0,0 +1,55 @@
+package slogtfmt
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// LogPanic recovers a panic and logs it at the error level with the recovered value
+// and the stack trace as the "panic" and "stack" attributes. If repanic is true,
+// the panic is resumed after it is logged, otherwise it is swallowed.
+// The source of the record is the location of the panic.
+//
+// LogPanic must be called directly by a deferred function call:
+//
+//	defer slogtfmt.LogPanic(logger, false)
+func LogPanic(logger *slog.Logger, repanic bool) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	ctx := context.Background()
+	if logger.Enabled(ctx, slog.LevelError) {
+		r := slog.NewRecord(time.Now(), slog.LevelError, "panic recovered", panicPC())
+		r.AddAttrs(
+			slog.Any("panic", v),
+			slog.String("stack", string(debug.Stack())),
+		)
+		_ = logger.Handler().Handle(ctx, r)
+	}
+	if repanic {
+		panic(v)
+	}
+}
+
+// panicPC returns the program counter of the location of the panic being recovered by the caller,
+// which is the first frame outside the runtime below runtime.gopanic, or 0 if it isn't found.
+func panicPC() uintptr {
+	var pcs [64]uintptr
+	n := runtime.Callers(3, pcs[:]) // skip [runtime.Callers, panicPC, LogPanic]
+	inPanic := false
+	for _, pc := range pcs[:n] {
+		frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+		switch {
+		case frame.Function == "runtime.gopanic":
+			inPanic = true
+		case inPanic && !strings.HasPrefix(frame.Function, "runtime."):
+			return pc
+		}
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package slogtfmt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func panickingFunc(logger *slog.Logger, repanic bool) {
+	defer LogPanic(logger, repanic)
+	panic(errors.New("something went wrong"))
+}
+
+func TestLogPanic(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(&buf, &Options{TimeFormat: ""}))
+
+	assert.NotPanics(t, func() { panickingFunc(logger, false) })
+	out := buf.String()
+	assert.Contains(t, out, "ERROR\tpanic recovered panic=something went wrong stack=\"goroutine ")
+	assert.Contains(t, out, "panickingFunc")
+
+	buf.Reset()
+	assert.PanicsWithError(t, "something went wrong", func() { panickingFunc(logger, true) })
+	assert.Contains(t, buf.String(), "ERROR\tpanic recovered panic=something went wrong")
+}
+
+func TestLogPanicWithoutPanic(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(&buf, &Options{TimeFormat: ""}))
+
+	func() {
+		defer LogPanic(logger, true)
+	}()
+	assert.Empty(t, buf.String())
+}
+
+func TestLogPanicSource(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(&buf, &Options{TimeFormat: "", AddSource: true}))
+
+	var file string
+	var line int
+	func() {
+		defer LogPanic(logger, false)
+		_, file, line, _ = runtime.Caller(0)
+		panic("explicit")
+	}()
+	assert.Contains(t, buf.String(), fmt.Sprintf("ERROR\t%s:%d\tpanic recovered", file, line+1))
+
+	// Runtime errors are located at the faulting code as well.
+	buf.Reset()
+	func() {
+		defer LogPanic(logger, false)
+		var m map[string]int
+		_, file, line, _ = runtime.Caller(0)
+		m["a"] = 1
+	}()
+	assert.Contains(t, buf.String(), fmt.Sprintf("ERROR\t%s:%d\tpanic recovered", file, line+1))
+}
@@ -0,0 +1,29 @@
+// Package slogproto renders protocol buffer messages logged with slogtfmt in the compact
+// single-line text format instead of the default verbose Go representation.
+//
+// The package is separate from slogtfmt to keep the protocol buffers dependency
+// out of the core handler.
+package slogproto
+
+import (
+	"log/slog"
+
+	"github.com/corvax/slogtfmt"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+)
+
+// Formatter is a [slogtfmt.TypeFormatter] that renders [proto.Message] values
+// in the compact single-line protobuf text format.
+func Formatter(v any) (slog.Value, bool) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return slog.Value{}, false
+	}
+	return slog.StringValue(prototext.MarshalOptions{}.Format(m)), true
+}
+
+// WithProto returns a slogtfmt Option that enables compact rendering of protocol buffer messages.
+func WithProto() slogtfmt.Option {
+	return slogtfmt.WithTypeFormatters(Formatter)
+}
@@ -0,0 +1,39 @@
+package slogproto
+
+import (
+	"bytes"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/corvax/slogtfmt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestWithProto(t *testing.T) {
+	msg := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("service.proto"),
+		Package:    proto.String("api.v1"),
+		Dependency: []string{"common.proto", "types.proto"},
+	}
+
+	var buf bytes.Buffer
+	handler := slogtfmt.NewHandlerWithOptions(&buf, slogtfmt.WithTimeFormat(""), WithProto())
+	slog.New(handler).Info("Loaded", "file", msg, "count", 2)
+
+	// The protobuf text format is deliberately unstable, so compare with its own output.
+	compact := prototext.MarshalOptions{}.Format(msg)
+	expected := "INFO\tLoaded file=" + strconv.Quote(compact) + " count=2\n"
+	assert.Equal(t, expected, buf.String())
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, compact, `name:"service.proto"`)
+}
+
+func TestFormatterIgnoresOtherValues(t *testing.T) {
+	_, ok := Formatter(struct{ A int }{1})
+	assert.False(t, ok)
+}
@@ -0,0 +1,170 @@
+package slogtfmt
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/slogtest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogtest(t *testing.T) {
+	var buf bytes.Buffer
+	newHandler := func(t *testing.T) slog.Handler {
+		buf.Reset()
+		return NewHandlerWithOptions(&buf, WithTimeFormat(time.RFC3339Nano), WithFullyKeyed(true))
+	}
+	result := func(t *testing.T) map[string]any {
+		return parseNested(t, buf.String())
+	}
+	slogtest.Run(t, newHandler, result)
+}
+
+// parseNested parses the FullyKeyed output into a map with a nested map for each group,
+// the format expected by slogtest.
+func parseNested(t *testing.T, line string) map[string]any {
+	t.Helper()
+	fields, err := parseLogfmt(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := map[string]any{}
+	for _, f := range fields {
+		group := m
+		keys := strings.Split(f.key, ".")
+		for _, k := range keys[:len(keys)-1] {
+			g, ok := group[k].(map[string]any)
+			if !ok {
+				g = map[string]any{}
+				group[k] = g
+			}
+			group = g
+		}
+		group[keys[len(keys)-1]] = f.value
+	}
+	return m
+}
+
+// TestGroupSemantics compares the output of WithGroup and WithAttrs chains with the output
+// of slog.JSONHandler, the reference implementation.
+func TestGroupSemantics(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(slog.Handler) slog.Handler
+		attrs []slog.Attr
+	}{
+		{"empty group without attrs", func(h slog.Handler) slog.Handler { return h.WithGroup("g") }, nil},
+		{"empty group with record attrs", func(h slog.Handler) slog.Handler { return h.WithGroup("g") }, []slog.Attr{slog.String("a", "1")}},
+		{"trailing empty group", func(h slog.Handler) slog.Handler {
+			return h.WithGroup("g").WithAttrs([]slog.Attr{slog.String("a", "1")}).WithGroup("h")
+		}, nil},
+		{"nested empty groups with record attrs", func(h slog.Handler) slog.Handler {
+			return h.WithGroup("g").WithGroup("h")
+		}, []slog.Attr{slog.String("a", "1")}},
+		{"empty group followed by attrs in a later call", func(h slog.Handler) slog.Handler {
+			return h.WithGroup("g").WithAttrs(nil).WithGroup("h").WithAttrs([]slog.Attr{slog.String("b", "2")})
+		}, nil},
+		{"attrs between groups", func(h slog.Handler) slog.Handler {
+			return h.WithAttrs([]slog.Attr{slog.String("a", "1")}).WithGroup("g").WithAttrs([]slog.Attr{slog.String("b", "2")}).WithGroup("h")
+		}, []slog.Attr{slog.String("c", "3")}},
+		{"empty record group", func(h slog.Handler) slog.Handler { return h.WithGroup("g") }, []slog.Attr{slog.Group("x")}},
+		{"record group with empty attrs", func(h slog.Handler) slog.Handler { return h }, []slog.Attr{slog.Group("x", slog.Attr{})}},
+		{"record group with empty group", func(h slog.Handler) slog.Handler { return h }, []slog.Attr{slog.Group("x", slog.Group("y")), slog.String("a", "1")}},
+		{"inline group in group", func(h slog.Handler) slog.Handler { return h.WithGroup("g") }, []slog.Attr{slog.Group("", slog.String("a", "1"), slog.Group("", slog.String("b", "2")))}},
+		{"inline group at top level", func(h slog.Handler) slog.Handler { return h }, []slog.Attr{slog.Group("", slog.String("a", "1")), slog.String("b", "2")}},
+		{"inline group in record group", func(h slog.Handler) slog.Handler { return h }, []slog.Attr{slog.Group("x", slog.Group("", slog.String("a", "1"), slog.Group("y", slog.Group("", slog.String("b", "2")))))}},
+		{"inline group in WithAttrs", func(h slog.Handler) slog.Handler {
+			return h.WithAttrs([]slog.Attr{slog.Group("", slog.String("a", "1"))}).WithGroup("g").WithAttrs([]slog.Attr{slog.Group("", slog.String("b", "2"))})
+		}, []slog.Attr{slog.Group("", slog.String("c", "3"))}},
+		{"empty inline group", func(h slog.Handler) slog.Handler { return h.WithGroup("g") }, []slog.Attr{slog.Group(""), slog.Group("x", slog.Group(""))}},
+		{"empty attrs with group", func(h slog.Handler) slog.Handler {
+			return h.WithGroup("g").WithAttrs([]slog.Attr{{}})
+		}, []slog.Attr{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var text, json bytes.Buffer
+			textHandler := tt.build(NewHandlerWithOptions(&text, WithTimeFormat(""), WithFullyKeyed(true)))
+			jsonHandler := tt.build(slog.NewJSONHandler(&json, &slog.HandlerOptions{
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if len(groups) == 0 && a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			}))
+			for _, h := range []slog.Handler{textHandler, jsonHandler} {
+				r := slog.NewRecord(time.Time{}, slog.LevelInfo, "msg", 0)
+				r.AddAttrs(tt.attrs...)
+				if err := h.Handle(context.Background(), r); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var want map[string]any
+			if err := stdjson.Unmarshal(json.Bytes(), &want); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, want, parseNested(t, text.String()), "JSON: %s", json.String())
+		})
+	}
+}
+
+// parsePositional parses a line of the positional format with the default header separator into
+// a map with the time, level and msg keys and a nested map for each group.
+func parsePositional(t *testing.T, line string) map[string]any {
+	t.Helper()
+	segments := strings.Split(line, "\t")
+	m := map[string]any{}
+	if len(segments) == 3 {
+		m[slog.TimeKey] = segments[0]
+		segments = segments[1:]
+	}
+	if len(segments) != 2 {
+		t.Fatalf("unexpected header in %q", line)
+	}
+	m[slog.LevelKey] = segments[0]
+	msg, attrs, _ := strings.Cut(segments[1], " ")
+	for k, v := range parseNested(t, attrs) {
+		m[k] = v
+	}
+	m[slog.MessageKey] = msg
+	return m
+}
+
+func TestSlogtestOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func(*testing.T, string) map[string]any
+		opts  []Option
+	}{
+		{"positional", parsePositional, nil},
+		{"positional sorted", parsePositional, []Option{WithSortAttrs(SortGrouped)}},
+		{"positional deduped", parsePositional, []Option{WithDedupKeys(true), WithPriorityKeys("G.a")}},
+		{"keyed", parseNested, []Option{WithFullyKeyed(true)}},
+		{"keyed tabular", parseNested, []Option{WithFullyKeyed(true), WithTabularAttrs(true)}},
+		{"keyed collected", parseNested, []Option{WithFullyKeyed(true), WithSortAttrs(SortByKey), WithAddRecordID(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			opts := append([]Option{WithTimeFormat(time.RFC3339Nano)}, tt.opts...)
+			handler := NewHandlerWithOptions(&buf, opts...)
+			err := slogtest.TestHandler(handler, func() []map[string]any {
+				var results []map[string]any
+				for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+					m := tt.parse(t, strings.TrimRight(line, " "))
+					delete(m, "record_id")
+					results = append(results, m)
+				}
+				return results
+			})
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package slogtfmt
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+)
+
+// The message of the stats record written with StatsInterval.
+const statsMessage = "handler stats"
+
+// Stats are the numbers of the records handled by a Handler and the handlers derived from it,
+// by outcome, so the records lost by the Handler can be observed.
+type Stats struct {
+	// Written is the number of the records written to the output.
+	Written uint64
+	// Folded is the number of the repeated records suppressed by FoldRepeats.
+	Folded uint64
+	// DroppedLevel is the number of the records dropped by LevelFunc at Handle time.
+	DroppedLevel uint64
+	// DroppedInvalidKeys is the number of the records dropped by StrictKeys.
+	DroppedInvalidKeys uint64
+	// DroppedTimeout is the number of the records not written within the WriteTimeout,
+	// including the records whose write timed out but completes later.
+	DroppedTimeout uint64
+	// DroppedWriteError is the number of the records dropped because of the other write errors.
+	DroppedWriteError uint64
+}
+
+// statsCounters counts the records for Stats. It's safe for concurrent use.
+type statsCounters struct {
+	written            atomic.Uint64
+	folded             atomic.Uint64
+	droppedLevel       atomic.Uint64
+	droppedInvalidKeys atomic.Uint64
+	droppedTimeout     atomic.Uint64
+	droppedWriteError  atomic.Uint64
+}
+
+// countDropped counts a record dropped because Handle failed with the error.
+func (c *statsCounters) countDropped(err error) {
+	switch {
+	case errors.Is(err, ErrInvalidKey):
+		c.droppedInvalidKeys.Add(1)
+	case errors.Is(err, ErrWriteTimeout):
+		c.droppedTimeout.Add(1)
+	default:
+		c.droppedWriteError.Add(1)
+	}
+}
+
+// Stats returns the numbers of the records handled by the Handler and the handlers derived
+// from it with WithAttrs and WithGroup, since it was created.
+func (h *Handler) Stats() Stats {
+	c := &h.state.stats
+	return Stats{
+		Written:            c.written.Load(),
+		Folded:             c.folded.Load(),
+		DroppedLevel:       c.droppedLevel.Load(),
+		DroppedInvalidKeys: c.droppedInvalidKeys.Load(),
+		DroppedTimeout:     c.droppedTimeout.Load(),
+		DroppedWriteError:  c.droppedWriteError.Load(),
+	}
+}
+
+// writeStats writes the stats record if the StatsInterval has passed since the last one,
+// or since the first record. The caller must hold the mutex.
+func (h *Handler) writeStats() error {
+	now := h.opts.Clock()
+	if h.state.lastStats.IsZero() {
+		h.state.lastStats = now
+		return nil
+	}
+	if now.Sub(h.state.lastStats) < h.opts.StatsInterval {
+		return nil
+	}
+	h.state.lastStats = now
+
+	s := h.Stats()
+	r := slog.NewRecord(now, slog.LevelInfo, statsMessage, 0)
+	r.AddAttrs(
+		slog.Uint64("written", s.Written),
+		slog.Uint64("folded", s.Folded),
+		slog.Uint64("dropped_level", s.DroppedLevel),
+		slog.Uint64("dropped_invalid_keys", s.DroppedInvalidKeys),
+		slog.Uint64("dropped_timeout", s.DroppedTimeout),
+		slog.Uint64("dropped_write_error", s.DroppedWriteError),
+	)
+
+	// Like the summary of folded records, the stats record doesn't carry the attributes of the handler.
+	root := *h
+	root.goas = nil
+	buf, _ := root.appendRecord(nil, context.Background(), r)
+	return h.writeTimeout(buf, r.Time)
+}
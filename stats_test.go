@@ -0,0 +1,81 @@
+package slogtfmt
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerStats(t *testing.T) {
+	w := &slowWriter{release: make(chan struct{})}
+	handler := NewHandlerWithOptions(w, WithTimeFormat(""), WithWriteTimeout(10*time.Millisecond))
+	ctx := context.Background()
+	record := func(msg string) slog.Record {
+		return slog.NewRecord(time.Now(), slog.LevelInfo, msg, 0)
+	}
+
+	// The writer is stalled: the first write times out and the next records are dropped.
+	for _, msg := range []string{"first", "dropped", "dropped"} {
+		assert.ErrorIs(t, handler.Handle(ctx, record(msg)), ErrWriteTimeout)
+	}
+	assert.Equal(t, Stats{DroppedTimeout: 3}, handler.Stats())
+
+	close(w.release)
+	assert.Eventually(t, func() bool {
+		return handler.Handle(ctx, record("second")) == nil
+	}, time.Second, time.Millisecond)
+	stats := handler.Stats()
+	assert.Equal(t, uint64(1), stats.Written)
+	assert.GreaterOrEqual(t, stats.DroppedTimeout, uint64(3))
+
+	// The derived handlers share the counters.
+	handler.WithAttrs([]slog.Attr{slog.Int("n", 1)}).Handle(ctx, record("third"))
+	assert.Equal(t, uint64(2), handler.Stats().Written)
+}
+
+func TestHandlerStatsDropReasons(t *testing.T) {
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithStrictKeys(true), WithFoldRepeats(true),
+		WithLevelFunc(func(time.Time) slog.Level { return slog.LevelWarn }))
+	logger := slog.New(handler)
+
+	// The level is checked again by Handle, for the record time.
+	assert.NoError(t, handler.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "Filtered", 0)))
+	logger.Warn("Invalid", "", 1)
+	logger.Warn("Repeated")
+	logger.Warn("Repeated")
+	logger.Warn("Repeated")
+	assert.Equal(t, Stats{Written: 1, Folded: 2, DroppedLevel: 1, DroppedInvalidKeys: 1}, handler.Stats())
+
+	handler = NewHandlerWithOptions(failingWriter{}, WithTimeFormat(""))
+	slog.New(handler).Info("Lost")
+	assert.Equal(t, Stats{DroppedWriteError: 1}, handler.Stats())
+}
+
+func TestHandlerStatsInterval(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithStatsInterval(time.Minute),
+		WithClock(func() time.Time { return now }))
+	logger := slog.New(handler).With("id", 1)
+
+	logger.Info("First")
+	now = now.Add(30 * time.Second)
+	logger.Info("Second")
+	assert.Equal(t, "INFO\tFirst id=1\nINFO\tSecond id=1\n", buf.String())
+
+	// The stats are written before the first record after the interval.
+	buf.Reset()
+	now = now.Add(30 * time.Second)
+	logger.Info("Third")
+	logger.Info("Fourth")
+	assert.Equal(t,
+		"INFO\thandler stats written=2 folded=0 dropped_level=0 dropped_invalid_keys=0 dropped_timeout=0 dropped_write_error=0\n"+
+			"INFO\tThird id=1\n"+
+			"INFO\tFourth id=1\n",
+		buf.String())
+}
@@ -0,0 +1,104 @@
+package slogtfmt
+
+import (
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	logValuerType = reflect.TypeOf((*slog.LogValuer)(nil)).Elem()
+)
+
+// StructAttrs returns the exported fields of the struct v, or of the struct v points to,
+// as attributes, so a whole config or request struct can be logged in one call:
+//
+//	logger.LogAttrs(ctx, slog.LevelInfo, "Loaded config", slogtfmt.StructAttrs(cfg)...)
+//
+// The attribute keys are the field names or the names set with `log:"name"` tags.
+// The "omitempty" tag option drops zero values, e.g. `log:"port,omitempty"`, and the "-" tag
+// skips the field. Nested structs become groups, and embedded structs without a tag name are
+// inlined. Structs implementing slog.LogValuer and time.Time values are logged as values.
+// It returns nil if v is not a struct or a non-nil pointer to a struct.
+func StructAttrs(v any) []slog.Attr {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	return structAttrs(rv)
+}
+
+// structAttrs returns the attributes of the exported fields of the struct value.
+func structAttrs(rv reflect.Value) []slog.Attr {
+	rt := rv.Type()
+	attrs := make([]slog.Attr, 0, rt.NumField())
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		// The exported fields of embedded structs are promoted, even if the struct type is unexported.
+		if !field.IsExported() && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		tag := field.Tag.Get("log")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		omitEmpty := slices.Contains(strings.Split(opts, ","), "omitempty")
+
+		fv := rv.Field(i)
+		if omitEmpty && fv.IsZero() {
+			continue
+		}
+		if name == "" {
+			if field.Anonymous && isStruct(fv) {
+				// Inline the embedded struct.
+				attrs = append(attrs, slog.Attr{Key: "", Value: slog.GroupValue(structAttrs(derefStruct(fv))...)})
+				continue
+			}
+			name = field.Name
+		}
+
+		if isStruct(fv) {
+			attrs = append(attrs, slog.Attr{Key: name, Value: slog.GroupValue(structAttrs(derefStruct(fv))...)})
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		attrs = append(attrs, slog.Any(name, fv.Interface()))
+	}
+	return attrs
+}
+
+// isStruct reports whether the value is a struct, or a non-nil pointer to a struct,
+// that is logged as a group.
+func isStruct(fv reflect.Value) bool {
+	t := fv.Type()
+	if t.Implements(logValuerType) {
+		return false
+	}
+	if t.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			return false
+		}
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType
+}
+
+// derefStruct returns the struct value the value points to, or the value itself.
+func derefStruct(fv reflect.Value) reflect.Value {
+	if fv.Kind() == reflect.Pointer {
+		return fv.Elem()
+	}
+	return fv
+}
@@ -0,0 +1,63 @@
+package slogtfmt
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type structAddress struct {
+	City string `log:"city"`
+	Zip  string `log:"zip,omitempty"`
+}
+
+type structBase struct {
+	ID int `log:"id"`
+}
+
+type structUser struct {
+	structBase
+	Name     string         `log:"name"`
+	Email    string         `log:"email,omitempty"`
+	Age      int            `log:",omitempty"`
+	Password string         `log:"-"`
+	Address  structAddress  `log:"address"`
+	Billing  *structAddress `log:"billing,omitempty"`
+	Created  time.Time      `log:"created"`
+	Timeout  time.Duration
+	secret   string
+}
+
+func TestStructAttrs(t *testing.T) {
+	user := structUser{
+		structBase: structBase{ID: 7},
+		Name:       "alice",
+		Password:   "hunter2",
+		Address:    structAddress{City: "Paris"},
+		Created:    time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+		Timeout:    time.Second,
+		secret:     "s",
+	}
+
+	var buf bytes.Buffer
+	handler := NewHandlerWithOptions(&buf, WithTimeFormat(""), WithTimeAttributeFormat(time.DateOnly))
+	slog.New(handler).LogAttrs(context.Background(), slog.LevelInfo, "user", StructAttrs(&user)...)
+	assert.Equal(t, "INFO\tuser id=7 name=\"alice\" address.city=\"Paris\" created=2024-06-01 Timeout=1s\n", buf.String())
+
+	// Set omitempty fields and nested pointers are included.
+	user.Email = "alice@example.com"
+	user.Age = 30
+	user.Billing = &structAddress{City: "Lyon", Zip: "69000"}
+	buf.Reset()
+	slog.New(handler).LogAttrs(context.Background(), slog.LevelInfo, "user", StructAttrs(user)...)
+	assert.Equal(t, "INFO\tuser id=7 name=\"alice\" email=\"alice@example.com\" Age=30 address.city=\"Paris\""+
+		" billing.city=\"Lyon\" billing.zip=\"69000\" created=2024-06-01 Timeout=1s\n", buf.String())
+
+	assert.Nil(t, StructAttrs(nil))
+	assert.Nil(t, StructAttrs((*structUser)(nil)))
+	assert.Nil(t, StructAttrs(42))
+}
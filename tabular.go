@@ -0,0 +1,51 @@
+package slogtfmt
+
+import "unicode/utf8"
+
+// tabularWindow is the number of records after which a column width
+// that hasn't been reached again shrinks to the width of the current value.
+const tabularWindow = 100
+
+// tabularState tracks the column widths of attribute values when TabularAttrs is set.
+type tabularState struct {
+	records uint64 // number of formatted records
+	columns map[string]tabularColumn
+
+	// padStart and padEnd are the positions of the padding of the last padded value
+	// of the current record in the buffer, so only the padding is trimmed at the end.
+	padStart, padEnd int
+}
+
+type tabularColumn struct {
+	width   int
+	updated uint64 // the record number when the width was last reached
+}
+
+// pad pads the value starting at buf[start:] with spaces to the width of the column for the key.
+func (t *tabularState) pad(buf []byte, key string, start int) []byte {
+	if t.columns == nil {
+		t.columns = make(map[string]tabularColumn)
+	}
+
+	width := utf8.RuneCount(buf[start:])
+	col, ok := t.columns[key]
+	if !ok || width >= col.width || t.records-col.updated > tabularWindow {
+		t.columns[key] = tabularColumn{width: width, updated: t.records}
+		return buf
+	}
+
+	t.padStart = len(buf)
+	for ; width < col.width; width++ {
+		buf = append(buf, ' ')
+	}
+	t.padEnd = len(buf)
+	return buf
+}
+
+// trim removes the padding of the last value of the current record, if it ends the buffer.
+func (t *tabularState) trim(buf []byte) []byte {
+	if len(buf) == t.padEnd {
+		return buf[:t.padStart]
+	}
+	return buf
+}